@@ -0,0 +1,207 @@
+/*
+Copyright © 2022 Austin Poor <code@austinpoor.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/spf13/cobra"
+)
+
+// ctlCmd represents the ctl command
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control the processes of a running fun-run.",
+	Long: `Control the processes of a running fun-run instance
+through its control socket (by default, ".fun-run.sock"
+next to the config file).
+
+The socket's path comes from the config file, which is found
+the same way as for "fun-run run" (or can be given with -f),
+unless --socket is set.
+
+Use the --json flag to print the results as JSON.`,
+}
+
+// sendCtl sends a request to the control socket and prints the result,
+// exiting if the request fails.
+func sendCtl(cmd *cobra.Command, req funrun.CtlRequest) {
+	socket := ctlSocket(cmd)
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	// Send the request...
+	res, err := funrun.SendCtl(socket, req)
+	if err != nil {
+		if asJSON && res != nil {
+			printJSON(res)
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	// Print the result...
+	if asJSON {
+		printJSON(res)
+		return
+	}
+	if req.Op != funrun.CtlList && req.Op != funrun.CtlStatus {
+		fmt.Printf("%s: ok\n", req.Name)
+		return
+	}
+	printProcTable(res.Procs)
+}
+
+// ctlSocket returns the path of the control socket: the one given with
+// --socket or, if that isn't set, the one set by the config file.
+func ctlSocket(cmd *cobra.Command) string {
+	if cmd.Flags().Changed("socket") {
+		socket, _ := cmd.Flags().GetString("socket")
+		return socket
+	}
+
+	// Find the config file (falling back to the default
+	// socket in the current directory)...
+	paths, _ := cmd.Flags().GetStringArray("file")
+	if len(paths) == 0 {
+		p, err := funrun.FindConf(".")
+		if err != nil {
+			return funrun.DefaultSocket
+		}
+		paths = []string{p}
+	}
+
+	// ...and get the socket from it
+	socket, err := funrun.SocketPath(paths...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+	return socket
+}
+
+func printJSON(v any) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(b))
+}
+
+func printProcTable(procs []funrun.ProcStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tPID\tUPTIME\tRESTARTS\tEXIT\tERROR")
+	for _, p := range procs {
+		pid, uptime, exit := "-", "-", "-"
+		if p.PID != 0 {
+			pid = fmt.Sprint(p.PID)
+		}
+		if p.StartedAt != nil {
+			uptime = time.Since(*p.StartedAt).Round(time.Second).String()
+		}
+		if p.ExitCode != nil {
+			exit = fmt.Sprint(*p.ExitCode)
+		} else if p.Signal != "" {
+			exit = p.Signal
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", p.Name, p.Status, pid, uptime, p.Restarts, exit, p.Error)
+	}
+	w.Flush()
+}
+
+var ctlListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the status of every process.",
+	Args:    cobra.NoArgs,
+	Aliases: []string{"ls"},
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlList})
+	},
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status NAME",
+	Short: "Show the status of a process.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlStatus, Name: args[0]})
+	},
+}
+
+var ctlStartCmd = &cobra.Command{
+	Use:   "start NAME",
+	Short: "Start a process that isn't running.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlStart, Name: args[0]})
+	},
+}
+
+var ctlStopCmd = &cobra.Command{
+	Use:   "stop NAME",
+	Short: "Stop a running process.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlStop, Name: args[0]})
+	},
+}
+
+var ctlRestartCmd = &cobra.Command{
+	Use:   "restart NAME",
+	Short: "Restart a process.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlRestart, Name: args[0]})
+	},
+}
+
+var ctlSignalCmd = &cobra.Command{
+	Use:   "signal NAME SIGNAL",
+	Short: "Send a signal to a running process.",
+	Long: `Send a signal (e.g. SIGHUP or HUP) to a running process
+and the rest of its process group.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCtl(cmd, funrun.CtlRequest{Op: funrun.CtlSignal, Name: args[0], Signal: args[1]})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ctlCmd)
+
+	ctlCmd.PersistentFlags().StringArrayP("file", "f", nil, "Config file of the running fun-run (to find its socket)")
+	ctlCmd.PersistentFlags().StringP("socket", "s", "", "Path of the control socket (default: the one set by the config file)")
+	ctlCmd.PersistentFlags().Bool("json", false, "Print the results as JSON")
+
+	ctlCmd.AddCommand(ctlListCmd)
+	ctlCmd.AddCommand(ctlStatusCmd)
+	ctlCmd.AddCommand(ctlStartCmd)
+	ctlCmd.AddCommand(ctlStopCmd)
+	ctlCmd.AddCommand(ctlRestartCmd)
+	ctlCmd.AddCommand(ctlSignalCmd)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2022 Austin Poor <code@austinpoor.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/spf13/cobra"
+)
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env [-f CONFIG_FILE]... PROC",
+	Short: "Print the environment a process would be run with.",
+	Long: `Print the environment variables a process would be run
+with, one KEY=value per line.
+
+That's fun-run's own environment (unless the process sets
+clear_envs) overridden, in order, by the config's env_file and
+envs, then by the process's own env_file and envs, and then
+by its secrets. Secrets' values aren't printed, and are shown
+as <secret NAME> instead.
+
+The config file is found the same way as with "fun-run run", or
+can be given with -f.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Load the config...
+		conf, err := funrun.ReadConfFormat(stdinFormat(cmd), confPaths(cmd, nil)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Find the process...
+		for _, p := range conf.Procs {
+			if p.Name != args[0] {
+				continue
+			}
+
+			// ...and print its environment
+			for _, e := range p.Environ() {
+				fmt.Println(e)
+			}
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Error: unknown process %q\n", args[0])
+		os.Exit(1)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+
+	envCmd.Flags().StringArrayP("file", "f", nil, "Config file to read (can be repeated to merge configs)")
+	envCmd.Flags().String("format", "", "Format of a config read from stdin (yaml, json or toml)")
+}
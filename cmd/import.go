@@ -0,0 +1,152 @@
+/*
+Copyright © 2022 Austin Poor <code@austinpoor.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Convert another tool's config to a fun-run config file.",
+	Long: `Convert another tool's config (a Procfile or a
+docker-compose file) to a fun-run config file.`,
+}
+
+// importProcfileCmd represents the import procfile command
+var importProcfileCmd = &cobra.Command{
+	Use:   "procfile [PROCFILE]",
+	Short: "Convert a Procfile to a fun-run config file.",
+	Long: `Convert a Procfile (and the .env file next to it) to a
+fun-run config file. If no Procfile path is provided, "Procfile"
+will be used.
+
+Each process's command is run with the shell and, like foreman,
+each process gets its own PORT (5000, 5100, ...). If the .env
+file sets PORT, that's used as the first port instead.
+
+To print to stdout instead of a file, use "-" as the --output
+path.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get the Procfile path...
+		p := "Procfile"
+		if len(args) > 0 {
+			p = args[0]
+		}
+
+		// Read the Procfile...
+		b, err := os.ReadFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading Procfile: %v\n", err)
+			os.Exit(1)
+		}
+
+		// ...and the env file
+		envFile, _ := cmd.Flags().GetString("env-file")
+		if !cmd.Flags().Changed("env-file") {
+			envFile = filepath.Join(filepath.Dir(p), envFile)
+		}
+		envs, err := funrun.ReadDotEnv(envFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading env file: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Convert it...
+		cfg, err := funrun.ProcfileConf(b, envs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing Procfile: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Write the config file...
+		out, _ := cmd.Flags().GetString("output")
+		writeConf(cmd, cfg, out, funrun.FormatOf(out))
+	},
+}
+
+// importComposeCmd represents the import compose command
+var importComposeCmd = &cobra.Command{
+	Use:   "compose [COMPOSE_FILE]",
+	Short: "Convert a docker-compose file to a fun-run config file.",
+	Long: `Convert the services in a docker-compose file to a fun-run
+config file. If no compose file path is provided,
+"docker-compose.yml" will be used.
+
+Each service's command, entrypoint, environment, env_file,
+working_dir, depends_on and restart are converted. Anything
+else (e.g. image, ports or volumes) only makes sense for
+containers, so it's skipped with a warning.
+
+A working_dir that only exists in the container is skipped,
+and healthchecks aren't converted, so add a ready check to
+each service that others wait for with service_healthy.
+
+To print to stdout instead of a file, use "-" as the --output
+path.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get the compose file path...
+		p := "docker-compose.yml"
+		if len(args) > 0 {
+			p = args[0]
+		}
+
+		// Read the compose file...
+		b, err := os.ReadFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading compose file: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Convert it...
+		cfg, warnings, err := funrun.ComposeConf(b, filepath.Dir(p))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing compose file: %v\n", err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+
+		// Write the config file...
+		out, _ := cmd.Flags().GetString("output")
+		writeConf(cmd, cfg, out, funrun.FormatOf(out))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importProcfileCmd)
+	importCmd.AddCommand(importComposeCmd)
+
+	importProcfileCmd.Flags().StringP("output", "o", "fun-run.yaml", "Path of the config file to write")
+	importProcfileCmd.Flags().StringP("env-file", "e", ".env", "Path of the env file (relative to the Procfile unless set)")
+	importComposeCmd.Flags().StringP("output", "o", "fun-run.yaml", "Path of the config file to write")
+}
@@ -27,7 +27,6 @@ import (
 
 	"github.com/a-poor/fun-run/pkg/funrun"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 // initCmd represents the init command
@@ -38,6 +37,10 @@ var initCmd = &cobra.Command{
 file path is provided, the default config file path 
 ("fun-run.yaml") will be used.
 
+The config is written as YAML, JSON or TOML, based on the
+file's extension or the --format flag (with no path, the
+default file gets the format's extension, e.g. "fun-run.toml").
+
 To print to stdout instead of a file, use "-" as the 
 config file path.`,
 	Args:    cobra.MaximumNArgs(1),
@@ -75,46 +78,68 @@ config file path.`,
 			},
 		}
 
+		// Get the format...
+		format := funrun.FormatYAML
+		if s, _ := cmd.Flags().GetString("format"); s != "" {
+			f, err := funrun.ParseFormat(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			format = f
+		}
+
 		// Get the filepath to write to...
-		p := "fun-run.yaml"
+		p := "fun-run." + string(format)
 		if len(args) > 0 {
 			p = args[0]
+			if !cmd.Flags().Changed("format") && p != "-" {
+				format = funrun.FormatOf(p)
+			}
 		}
 
 		// Write the config file...
-		b, err := yaml.Marshal(&cfg)
-		if err != nil {
-			fmt.Printf("Error marshaling config file as yaml: %s\n", err)
-			os.Exit(1)
-		}
+		writeConf(cmd, &cfg, p, format)
+	},
+}
 
-		// Should we write to stdout? (Instead of a file)
-		if p == "-" {
-			cmd.Println(string(b))
-			return
-		}
+// writeConf marshals a config in the given format and writes it to
+// a file (or to stdout if the path is "-"), exiting on failure.
+func writeConf(cmd *cobra.Command, cfg *funrun.Conf, p string, format funrun.Format) {
+	b, err := funrun.MarshalConf(cfg, format)
+	if err != nil {
+		fmt.Printf("Error marshaling config file as %s: %s\n", format, err)
+		os.Exit(1)
+	}
 
-		// Create the file (or overwrite it if it exists)...
-		f, err := os.Create(p)
-		if err != nil {
-			cmd.Printf("Error creating config file: %s\n", err)
-			os.Exit(1)
-		}
-		defer f.Close()
+	// Should we write to stdout? (Instead of a file)
+	if p == "-" {
+		cmd.Println(string(b))
+		return
+	}
 
-		// Write the config file...
-		_, err = f.Write(b)
-		if err != nil {
-			fmt.Printf("Error writing config file: %s\n", err)
-			f.Close()
-			os.Exit(1)
-		}
+	// Create the file (or overwrite it if it exists)...
+	f, err := os.Create(p)
+	if err != nil {
+		cmd.Printf("Error creating config file: %s\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
 
-		// Done!
-		cmd.Printf("New fun-run config file written to: %s\n", p)
-	},
+	// Write the config file...
+	_, err = f.Write(b)
+	if err != nil {
+		fmt.Printf("Error writing config file: %s\n", err)
+		f.Close()
+		os.Exit(1)
+	}
+
+	// Done!
+	cmd.Printf("New fun-run config file written to: %s\n", p)
 }
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().String("format", "", "Format to write the config in (yaml, json or toml; default: based on the file's extension)")
 }
@@ -26,46 +26,202 @@ import (
 	"os"
 
 	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/a-poor/fun-run/pkg/tui"
 	"github.com/spf13/cobra"
 )
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use:   "run {- | CONFIG_FILE}",
+	Use:   "run [-f CONFIG_FILE]... [{- | CONFIG_FILE}] [PROC...]",
 	Short: "Start running your commands.",
 	Long: `Start running your commands based on the configuration file.
 
-To read from stdin, use '-' as the CONFIG_FILE argument.`,
-	Args:    cobra.ExactArgs(1),
+If no config file is given, the one in $FUN_RUN_CONFIG is used or,
+if that isn't set, the first fun-run.yaml, fun-run.yml, fun-run.json
+or fun-run.toml found in the current directory or its parents.
+
+To read from stdin, use '-' as the CONFIG_FILE argument. Config
+files can be YAML, JSON or TOML (based on their extension), and
+stdin is read as YAML unless --format is set.
+
+To layer configs (e.g. a shared base config and a local overlay),
+pass more than one with -f. They're deep-merged in order, with
+processes merged by name.
+
+To only run some of the processes, name them after the config
+file (or after the -f flags) and/or select a profile from the
+config with --profile. Anything the selected processes depend
+on is run as well.
+
+Use the --tui flag to show an interactive dashboard, with
+the status and logs of each process, instead of the usual
+prefixed output. Use --log-format json to write the output (and
+fun-run's own messages) as one JSON object per line instead, and
+--align, --show-stream, --timestamps and --show-pid to change the
+prefix before each line (see the config's "log" settings).
+
+While running, processes can be controlled with the
+"fun-run ctl" commands through a unix socket (by default,
+".fun-run.sock" next to the config file).`,
+	Args:    cobra.ArbitraryArgs,
 	Aliases: []string{"r"},
 	Run: func(cmd *cobra.Command, args []string) {
-		// Get the config file (or read from stdin)...
-		var p string
-		if len(args) > 0 {
-			p = args[0]
-		}
+		// Get the config files (or read from stdin) and
+		// the processes to run...
+		paths, procs := runArgs(cmd, args)
 
 		// Load the config...
-		conf, err := funrun.ReadConf(p)
+		conf, err := funrun.ReadConfFormat(stdinFormat(cmd), paths...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
 			os.Exit(1)
 		}
 
+		// Select the processes to run...
+		profiles, _ := cmd.Flags().GetStringArray("profile")
+		if err := conf.Select(procs, profiles); err != nil {
+			fmt.Fprintf(os.Stderr, "Error selecting processes: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Set the log format (the dashboard always shows text)...
+		useTUI, _ := cmd.Flags().GetBool("tui")
+		if cmd.Flags().Changed("log-format") {
+			if useTUI {
+				fmt.Fprintln(os.Stderr, "Error: --log-format can't be used with --tui")
+				os.Exit(1)
+			}
+			s, _ := cmd.Flags().GetString("log-format")
+			f, err := funrun.ParseLogFormat(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if conf.Log == nil {
+				conf.Log = &funrun.LogConf{}
+			}
+			conf.Log.Format = f
+		} else if useTUI && conf.Log != nil {
+			conf.Log.Format = funrun.LogFormatText
+		}
+
+		// ...and the prefix layout
+		for flag, set := range map[string]func(l *funrun.LogConf){
+			"align":       func(l *funrun.LogConf) { l.Align = true },
+			"show-stream": func(l *funrun.LogConf) { l.Stream = true },
+			"timestamps":  func(l *funrun.LogConf) { l.Timestamps = true },
+			"show-pid":    func(l *funrun.LogConf) { l.PID = true },
+		} {
+			if on, _ := cmd.Flags().GetBool(flag); on {
+				if conf.Log == nil {
+					conf.Log = &funrun.LogConf{}
+				}
+				set(conf.Log)
+			}
+		}
+
 		// Create the process manager...
 		man := funrun.NewManager(conf)
 
+		// Set up the control socket...
+		if noSocket, _ := cmd.Flags().GetBool("no-socket"); noSocket {
+			man.SetSocket("")
+		} else if cmd.Flags().Changed("socket") {
+			socket, _ := cmd.Flags().GetString("socket")
+			man.SetSocket(socket)
+		}
+
 		// Get the context...
 		ctx := cmd.Context()
 
-		// Start the processes...
-		if err := man.Run(ctx); err != nil {
+		// Start the processes (with or without the dashboard)...
+		if useTUI {
+			if err := tui.Run(ctx, man, conf); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running dashboard: %v\n", err)
+			}
+			if err := man.Error(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running processes: %v\n", err)
+			}
+		} else if err := man.Run(ctx); err != nil {
 			fmt.Fprintf(os.Stderr, "Error running processes: %v\n", err)
-			os.Exit(1)
+		}
+
+		// Exit with the right code (e.g. the code of the
+		// process that caused fun-run to stop)...
+		if code := man.ExitCode(); code != 0 {
+			os.Exit(code)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().StringArrayP("file", "f", nil, "Config file to read (can be repeated to merge configs)")
+	runCmd.Flags().String("format", "", "Format of a config read from stdin (yaml, json or toml)")
+	runCmd.Flags().StringArrayP("profile", "p", nil, "Only run the processes in this profile (can be repeated)")
+	runCmd.Flags().BoolP("tui", "t", false, "Show an interactive dashboard")
+	runCmd.Flags().String("log-format", "", "Format of the output (text or json; overrides the config's log.format)")
+	runCmd.Flags().Bool("align", false, "Pad the processes' names to the same width")
+	runCmd.Flags().Bool("show-stream", false, "Show which stream (stdout or stderr) each line is from")
+	runCmd.Flags().Bool("timestamps", false, "Show the time before each line")
+	runCmd.Flags().Bool("show-pid", false, "Show the process's ID in each line")
+	runCmd.Flags().StringP("socket", "s", "", "Path of the control socket (default: the one set by the config file)")
+	runCmd.Flags().Bool("no-socket", false, "Don't listen on a control socket")
+}
+
+// confPaths returns the config files passed to a command, with
+// the ones given with --file first. If there aren't any, the config
+// file is found with funrun.FindConf.
+func confPaths(cmd *cobra.Command, args []string) []string {
+	paths, _ := cmd.Flags().GetStringArray("file")
+	paths = append(paths, args...)
+	if len(paths) > 0 {
+		return paths
+	}
+	p, err := funrun.FindConf(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return []string{p}
+}
+
+// stdinFormat returns the format given with --format, for configs
+// read from stdin, exiting if it isn't a known format.
+func stdinFormat(cmd *cobra.Command) funrun.Format {
+	s, _ := cmd.Flags().GetString("format")
+	if s == "" {
+		return ""
+	}
+	f, err := funrun.ParseFormat(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return f
+}
+
+// runArgs splits the run command's arguments into the config files
+// and the names of the processes to run. Without --file, the first
+// argument is the config file if it's "-" or a file that exists
+// (otherwise, the config file is found with funrun.FindConf).
+func runArgs(cmd *cobra.Command, args []string) (paths, procs []string) {
+	if files, _ := cmd.Flags().GetStringArray("file"); len(files) > 0 {
+		return files, args
+	}
+	if len(args) > 0 && isConfArg(args[0]) {
+		return args[:1], args[1:]
+	}
+	return confPaths(cmd, nil), args
+}
+
+// isConfArg reports whether an argument names a config
+// file (rather than a process).
+func isConfArg(arg string) bool {
+	if arg == "-" {
+		return true
+	}
+	info, err := os.Stat(arg)
+	return err == nil && !info.IsDir()
 }
@@ -0,0 +1,68 @@
+/*
+Copyright © 2022 Austin Poor <code@austinpoor.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/spf13/cobra"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema for fun-run config files.",
+	Long: `Print the JSON Schema for fun-run config files (e.g. to
+get autocompletion and checks in an editor).
+
+The schema is generated from the config types themselves, so it
+always matches the version of fun-run that printed it. To check
+a config against it, use "fun-run validate --schema".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := funrun.MarshalSchema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating schema: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Should we write to a file? (Instead of stdout)
+		out, _ := cmd.Flags().GetString("output")
+		if out == "" || out == "-" {
+			fmt.Println(string(b))
+			return
+		}
+		if err := os.WriteFile(out, append(b, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing schema: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Schema written to: %s\n", out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+
+	schemaCmd.Flags().StringP("output", "o", "", "Path of the file to write the schema to (default: stdout)")
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2022 Austin Poor <code@austinpoor.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/spf13/cobra"
+)
+
+// secretsCmd represents the secrets command
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted secrets files.",
+	Long: `Manage the encrypted env files that secrets can be read
+from (with "encrypted_file" in the config's secrets).
+
+Files are encrypted with a key from $FUN_RUN_SECRETS_KEY or, if
+that isn't set, from the key file created by "fun-run secrets
+keygen". Keep the key out of version control; the encrypted
+files can be committed.`,
+}
+
+// secretsKeygenCmd represents the secrets keygen command
+var secretsKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create a new key for encrypting secrets.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := funrun.GenerateSecretsKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("New secrets key written to: %s\n", p)
+	},
+}
+
+// secretsEncryptCmd represents the secrets encrypt command
+var secretsEncryptCmd = &cobra.Command{
+	Use:   "encrypt [{- | ENV_FILE}]",
+	Short: "Encrypt an env file.",
+	Long: `Encrypt an env file (one KEY=value per line) so that it
+can be used as a secrets' "encrypted_file". To read from stdin,
+use "-" as the ENV_FILE (or leave it out).`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read the env file...
+		b, err := readInput(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading env file: %v\n", err)
+			os.Exit(1)
+		}
+
+		// ...check it...
+		if _, err := funrun.ParseDotEnv(bytes.NewReader(b)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing env file: %v\n", err)
+			os.Exit(1)
+		}
+
+		// ...and encrypt it
+		enc, err := funrun.EncryptSecrets(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encrypting secrets: %v\n", err)
+			os.Exit(1)
+		}
+		out, _ := cmd.Flags().GetString("output")
+		if out == "" || out == "-" {
+			fmt.Print(string(enc))
+			return
+		}
+		if err := os.WriteFile(out, enc, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing encrypted file: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Encrypted secrets written to: %s\n", out)
+	},
+}
+
+// secretsDecryptCmd represents the secrets decrypt command
+var secretsDecryptCmd = &cobra.Command{
+	Use:   "decrypt [{- | ENCRYPTED_FILE}]",
+	Short: "Print the contents of an encrypted env file.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b, err := readInput(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading encrypted file: %v\n", err)
+			os.Exit(1)
+		}
+		plain, err := funrun.DecryptSecrets(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting secrets: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(plain))
+	},
+}
+
+// readInput reads the file named by the first argument (or
+// stdin, if there isn't one or it's "-").
+func readInput(args []string) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(args[0])
+}
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsKeygenCmd)
+	secretsCmd.AddCommand(secretsEncryptCmd)
+	secretsCmd.AddCommand(secretsDecryptCmd)
+
+	secretsEncryptCmd.Flags().StringP("output", "o", "", "Path of the encrypted file to write (default: stdout)")
+}
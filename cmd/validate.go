@@ -31,21 +31,38 @@ import (
 
 // validateCmd represents the validate command
 var validateCmd = &cobra.Command{
-	Use:   "validate {- | CONFIG_FILE}",
+	Use:   "validate [-f CONFIG_FILE]... [{- | CONFIG_FILE}]",
 	Short: "Validate the configuration file",
 	Long: `Validate a fun-run configuration file. To read from stdin, 
-use '-' as the CONFIG_FILE argument.`,
-	Args:    cobra.ExactArgs(1),
+use '-' as the CONFIG_FILE argument (with --format, if it
+isn't YAML).
+
+If no config file is given, it's found the same way as with
+"fun-run run" (from $FUN_RUN_CONFIG, or by searching the current
+directory and its parents).
+
+If more than one config file is passed with -f, the merged
+config is validated.
+
+With --schema, the config is also checked against the config's
+JSON Schema (see "fun-run schema").`,
+	Args:    cobra.MaximumNArgs(1),
 	Aliases: []string{"v"},
 	Run: func(cmd *cobra.Command, args []string) {
-		// Get the config file (or read from stdin)...
-		var p string
-		if len(args) > 0 {
-			p = args[0]
+		// Get the config files (or read from stdin)...
+		paths := confPaths(cmd, args)
+		format := stdinFormat(cmd)
+
+		// Check it against the schema...
+		if useSchema, _ := cmd.Flags().GetBool("schema"); useSchema {
+			if err := funrun.ValidateSchema(format, paths...); err != nil {
+				fmt.Fprintf(os.Stderr, "Config doesn't match the schema: %v\n", err)
+				os.Exit(1)
+			}
 		}
 
 		// Load the config...
-		_, err := funrun.ReadConf(p)
+		_, err := funrun.ReadConfFormat(format, paths...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
 			os.Exit(1)
@@ -59,6 +76,10 @@ use '-' as the CONFIG_FILE argument.`,
 func init() {
 	rootCmd.AddCommand(validateCmd)
 
+	validateCmd.Flags().StringArrayP("file", "f", nil, "Config file to read (can be repeated to merge configs)")
+	validateCmd.Flags().String("format", "", "Format of a config read from stdin (yaml, json or toml)")
+	validateCmd.Flags().Bool("schema", false, "Also check the config against the JSON Schema")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -7,25 +7,92 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// defaultStopTimeout is how long a process is given to exit after
+// being asked to stop before it's killed.
+const defaultStopTimeout = 10 * time.Second
+
 // A command's status
 type CmdStatus int
 
 const (
 	CmdNotStarted CmdStatus = iota // The command hasn't started yet
 	CmdRunning                     // The command is running
+	CmdReady                       // The command is running and has passed its readiness checks
 	CmdDone                        // The command has finished successfully
 	CmdFailed                      // The command has finished with an error
 	CmdStopped                     // The command has been stopped
 )
 
+func (s CmdStatus) String() string {
+	switch s {
+	case CmdNotStarted:
+		return "not-started"
+	case CmdRunning:
+		return "running"
+	case CmdReady:
+		return "ready"
+	case CmdDone:
+		return "done"
+	case CmdFailed:
+		return "failed"
+	case CmdStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// event is a one-shot signal that can be waited on by
+// any number of goroutines.
+type event struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newEvent() *event {
+	return &event{ch: make(chan struct{})}
+}
+
+// fire marks the event as having happened. It is safe
+// to call more than once.
+func (e *event) fire() {
+	e.once.Do(func() { close(e.ch) })
+}
+
+// done returns a channel that is closed once the event fires.
+func (e *event) done() <-chan struct{} {
+	return e.ch
+}
+
 type Command struct {
-	conf   *ProcConf          // The configuration for this command
-	cmd    *exec.Cmd          // The command object
-	err    error              // The error returned by the command
-	status CmdStatus          // The current status of the command
-	cancel context.CancelFunc // The cancel function for the command context
+	conf     *ProcConf          // The configuration for this command
+	cmd      *exec.Cmd          // The command object
+	err      error              // The error returned by the command
+	status   CmdStatus          // The current status of the command
+	cancel   context.CancelFunc // The cancel function for the command context
+	stopped  bool               // Has the command been explicitly stopped?
+	started  *event             // Fired the first time the command starts
+	ready    *event             // Fired the first time the command is ready
+	exited   *event             // Fired once the command won't run again
+	stopping *event             // Fired when the command is stopped
+
+	restarts     int           // Number of times the command has been restarted
+	restartTimes []time.Time   // When the command was restarted (within the restart window)
+	failures     int           // Number of runs in a row that have failed
+	exitCode     int           // Exit code from the last run (-1 if it didn't exit normally)
+	exitSignal   os.Signal     // Signal that ended the last run (if any)
+	startedAt    time.Time     // When the current run started (zero if it isn't running)
+	lastRun      time.Duration // How long the last run lasted
+	proc         *os.Process   // The current run's process (nil if it isn't running)
+
+	secrets map[string]string // Secrets to set as environment variables
+
+	active bool          // Is the command being run by a manager?
+	idle   chan struct{} // Closed once the manager is done running the command
 	wout   *PrefixWriter
 	werr   *PrefixWriter
 	sync.RWMutex
@@ -33,7 +100,12 @@ type Command struct {
 
 func NewCommand(conf *ProcConf) *Command {
 	return &Command{
-		conf: conf,
+		conf:     conf,
+		started:  newEvent(),
+		ready:    newEvent(),
+		exited:   newEvent(),
+		stopping: newEvent(),
+		exitCode: -1,
 	}
 }
 
@@ -48,118 +120,64 @@ func (c *Command) SetOutputs(wout, werr *PrefixWriter) {
 	c.werr = werr
 }
 
-func (c *Command) makeEnvGetter() func(string) string {
-	return func(key string) string {
-		// Check for an environment variable set explicitly
-		v, ok := c.conf.Envs[key]
-		if ok {
-			return v
-		}
-
-		// Check if the rest of the environment is available
-		if c.conf.ClearEnvs {
-			return ""
-		}
-
-		// Otherwise, return the environment variable
-		return os.Getenv(key)
-	}
-}
-
 func (c *Command) fmtEnvSlice() []string {
-	// Create a slice for env vars...
-	var envs []string
-
-	// Add the rest of the environment if we're not clearing it...
-	if !c.conf.ClearEnvs {
-		for _, v := range os.Environ() {
-			envs = append(envs, v)
-		}
-	}
-
-	// Add the explicit env vars...
-	for k, v := range c.conf.Envs {
-		e := fmt.Sprintf("%s=%s", k, v)
-		envs = append(envs, e)
+	envs := c.conf.environ()
+	for i, e := range envs {
+		envs[i] = fillSecrets(e, c.secrets)
 	}
-
-	// Return the slice
 	return envs
 }
 
-func (c *Command) makeSingleCmd(ctx context.Context) *exec.Cmd {
-	envGetter := c.makeEnvGetter()
-
-	// Expand the command text
-	cmdTxt := os.Expand(c.conf.Cmd, envGetter)
-
-	// Expand the arguments
+// fillArgs returns the command's arguments, with its secrets filled in.
+func (c *Command) fillArgs() []string {
 	args := make([]string, len(c.conf.Args))
 	for i, arg := range c.conf.Args {
-		args[i] = os.Expand(arg, envGetter)
+		args[i] = fillSecrets(arg, c.secrets)
 	}
-
-	// Create the command...
-	return exec.CommandContext(
-		ctx,
-		cmdTxt,
-		args...,
-	)
+	return args
 }
 
-func (c *Command) makeMultiCmd(ctx context.Context) *exec.Cmd {
-	// Get the env getter...
-	envGetter := c.makeEnvGetter()
+// makeSingleCmd creates the command for a process with a "cmd". The
+// variables in the config have already been expanded (by ReadConf),
+// apart from the secrets.
+func (c *Command) makeSingleCmd() *exec.Cmd {
+	return exec.Command(fillSecrets(c.conf.Cmd, c.secrets), c.fillArgs()...)
+}
 
-	// Expand the commands...
+// makeMultiCmd creates the command for a process with "cmds", which
+// are joined together and run with the shell.
+func (c *Command) makeMultiCmd() *exec.Cmd {
+	// Join the commands...
 	cmds := make([]string, len(c.conf.Cmds))
 	for i, cmd := range c.conf.Cmds {
-		cmds[i] = os.Expand(cmd, envGetter)
+		cmds[i] = fillSecrets(cmd, c.secrets)
 	}
-
-	// Expand the arguments...
-	args := make([]string, len(c.conf.Args))
-	for i, arg := range c.conf.Args {
-		args[i] = os.Expand(arg, envGetter)
-	}
-
-	// Join the commands...
 	cmdTxt := strings.Join(cmds, "; ")
 
 	// Join together the command and the arguments...
 	allArgs := append(
 		[]string{"-c", cmdTxt},
-		c.conf.Args...,
+		c.fillArgs()...,
 	)
 
 	// Create the command and return...
-	return exec.CommandContext(
-		ctx,
+	return exec.Command(
 		"sh",
 		allArgs...,
 	)
 }
 
-func (c *Command) createCmd(ctx context.Context) *exec.Cmd {
-	// Create the command with the context
+func (c *Command) createCmd() *exec.Cmd {
+	// Create the command
 	var cmd *exec.Cmd
 	if c.conf.Cmd == "" {
-		cmd = c.makeMultiCmd(ctx)
+		cmd = c.makeMultiCmd()
 	} else {
-		cmd = c.makeSingleCmd(ctx)
-	}
-
-	if c.conf.Cmd == "" {
-		cmdTxt := strings.Join(c.conf.Cmds, "; ")
-		args := append(
-			[]string{"-c", cmdTxt},
-			c.conf.Args...,
-		)
-		cmd = exec.CommandContext(ctx, "sh", args...)
+		cmd = c.makeSingleCmd()
 	}
 
 	// Set the working directory
-	cmd.Dir = c.conf.WorkDir
+	cmd.Dir = fillSecrets(c.conf.WorkDir, c.secrets)
 	if cmd.Dir == "" {
 		cmd.Dir = "."
 	}
@@ -171,6 +189,10 @@ func (c *Command) createCmd(ctx context.Context) *exec.Cmd {
 	cmd.Stdout = c.wout
 	cmd.Stderr = c.werr
 
+	// Run it in its own process group so that any processes it starts
+	// can be stopped along with it
+	setProcGroup(cmd)
+
 	// Return the command
 	return cmd
 }
@@ -188,15 +210,24 @@ func (c *Command) Status() CmdStatus {
 }
 
 func (c *Command) Run(ctx context.Context) error {
+	// Signal that the command is done once we return
+	defer c.exitedEvent().fire()
+
 	// Start a loop...
 runloop:
 	for {
+		// Don't (re)start a command that's been stopped
+		if c.isStopped() {
+			c.setStatus(CmdStopped)
+			break runloop
+		}
+
 		// Create the context for the command
 		ctx, cancel := context.WithCancel(ctx)
-		c.cancel = cancel
+		c.setCancel(cancel)
 
 		// Create the command
-		c.cmd = c.createCmd(ctx)
+		c.cmd = c.createCmd()
 
 		select {
 		case <-ctx.Done():
@@ -205,47 +236,125 @@ runloop:
 			break runloop
 
 		default:
-			c.wout.Logf("Starting...\n")
+			c.wout.Event(EventStarting, "Starting...\n")
+
+			// Watch the output if readiness depends on it
+			var lp *logProbe
+			if c.conf.Ready != nil && c.conf.Ready.Log != "" {
+				var err error
+				lp, err = newLogProbe(c.conf.Ready.Log)
+				if err != nil {
+					cancel()
+					c.setStatus(CmdFailed)
+					c.setError(fmt.Errorf("invalid readiness log pattern: %w", err))
+					c.wout.Event(EventStartFailed, "Error starting command: %s\n", c.Error())
+					return c.Error()
+				}
+				c.wout.AddTap(lp)
+			}
 
 			// Start the command
 			c.setStatus(CmdRunning)
 			err := c.cmd.Start()
 			if err != nil {
 				// Store the error and cmd state
+				cancel()
+				c.wout.RemoveTap(lp)
 				c.setStatus(CmdFailed)
-				c.err = err
+				c.setError(err)
+				c.setExitStatus(-1, nil)
 
 				// Log the error
-				c.wout.Logf("Error starting command: %s\n", err)
+				c.wout.Event(EventStartFailed, "Error starting command: %s\n", err)
 
 				// Should we restart?
-				if c.conf.Restart == RestartOnFail || c.conf.Restart == RestartAlways {
-					c.wout.Logf("Restarting...\n")
-					continue runloop
+				if c.shouldRestart() {
+					if c.waitToRestart(true) {
+						continue runloop
+					}
 				}
 
 				// Otherwise, break out of the loop
-				return err
+				return c.Error()
 			}
 
-			// Wait for the command to finish
+			// Let anyone waiting on this command know it's up (and
+			// forget how any earlier run failed)
+			c.setProc(c.cmd.Process)
+			c.setExitStatus(-1, nil)
+			c.setError(nil)
+			c.started.fire()
+			probed := c.startReadyCheck(ctx, cancel, lp)
+
+			// Stop the command gracefully if the context is cancelled
+			// or if it hits its timeout
+			waited := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				c.stopOnCancel(ctx, c.cmd.Process, waited)
+			}()
+			timedOut := c.startTimeout(cancel, waited)
+
+			// Wait for the command to finish (and, if it's being
+			// stopped, for the rest of its process group to go too)
 			err = c.cmd.Wait()
+			close(waited)
+			<-stopped
+
+			// Write out anything left of its output
+			c.wout.Flush()
+			c.werr.Flush()
+
+			c.setProc(nil)
+			cancel()
+			c.wout.RemoveTap(lp)
+
+			// Record how it exited
+			code, sig := exitStatus(c.cmd.ProcessState)
+			c.setExitStatus(code, sig)
+			if _, ok := err.(*exec.ExitError); ok || err == nil {
+				err = nil
+				if sig != nil {
+					err = &ExitError{Code: code, Signal: sig}
+				} else if !c.isSuccessCode(code) {
+					err = &ExitError{Code: code}
+				}
+			}
+
+			// Did it fail its readiness check or time out?
+			if perr := <-probed; perr != nil {
+				err = perr
+			}
+			if <-timedOut {
+				err = &TimeoutError{Timeout: time.Duration(c.conf.Timeout)}
+			}
+
+			// Was the command stopped on purpose?
+			if c.isStopped() {
+				c.setStatus(CmdStopped)
+				c.wout.Event(EventStopped, "Stopped\n")
+				break runloop
+			}
+
 			if err != nil {
-				c.err = err
+				c.setError(err)
 				c.setStatus(CmdFailed)
 			} else {
 				c.setStatus(CmdDone)
-				c.err = nil
+				c.setError(nil)
 			}
 
 			// Should we restart?
-			if c.conf.Restart == RestartAlways || (c.conf.Restart == RestartOnFail && c.err != nil) {
-				c.wout.Logf("Restarting...\n")
-				continue runloop
+			if c.shouldRestart() {
+				if c.waitToRestart(c.Error() != nil) {
+					continue runloop
+				}
+				break runloop
 			}
 
 			// Otherwise, break out of the loop
-			c.wout.Logf("Finished\n")
+			c.wout.Event(EventFinished, "Finished\n")
 			break runloop
 		}
 	}
@@ -254,13 +363,286 @@ runloop:
 	return nil
 }
 
+// startTimeout cancels the running command if it's still running once
+// its timeout is reached. The waited channel should be closed once
+// the command has exited. The returned channel receives whether or
+// not the command timed out.
+func (c *Command) startTimeout(cancel context.CancelFunc, waited <-chan struct{}) <-chan bool {
+	res := make(chan bool, 1)
+
+	// Is there a timeout?
+	if c.conf.Timeout <= 0 {
+		res <- false
+		return res
+	}
+
+	go func() {
+		t := time.NewTimer(time.Duration(c.conf.Timeout))
+		defer t.Stop()
+
+		select {
+		case <-waited:
+			res <- false
+		case <-t.C:
+			c.wout.Event(EventTimedOut, "Timed out after %s\n", c.conf.Timeout)
+			cancel()
+			res <- true
+		}
+	}()
+	return res
+}
+
+// stopOnCancel stops the process once the context is cancelled. The
+// waited channel should be closed once the process has exited.
+func (c *Command) stopOnCancel(ctx context.Context, p *os.Process, waited <-chan struct{}) {
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		c.terminate(p, waited)
+	}
+}
+
+// stopSignal returns the signal used to ask the process to stop.
+func (c *Command) stopSignal() os.Signal {
+	if c.conf.StopSignal != "" {
+		if sig, err := parseSignal(c.conf.StopSignal); err == nil {
+			return sig
+		}
+	}
+	return syscall.SIGTERM
+}
+
+// stopTimeout returns how long the process has to exit after being
+// sent its stop signal before it's killed.
+func (c *Command) stopTimeout() time.Duration {
+	if c.conf.StopTimeout > 0 {
+		return time.Duration(c.conf.StopTimeout)
+	}
+	return defaultStopTimeout
+}
+
+// terminate asks the process (and the rest of its process group) to
+// stop by sending its stop signal and then, if anything is still
+// running after the grace period, kills the whole group. The waited
+// channel should be closed once the process has exited.
+func (c *Command) terminate(p *os.Process, waited <-chan struct{}) {
+	sig := c.stopSignal()
+	c.wout.Event(EventStopping, "Stopping (sending %s)...\n", signalName(sig))
+	if err := signalGroup(p, sig); err != nil {
+		// If the signal can't be sent, there's nothing to wait for
+		killGroup(p)
+		return
+	}
+
+	timeout := c.stopTimeout()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	// Wait for the process to exit...
+	select {
+	case <-waited:
+	case <-t.C:
+		c.wout.Event(EventKilling, "Still running after %s, sending SIGKILL...\n", timeout)
+		killGroup(p)
+		return
+	}
+
+	// ...and then for any of its children that are still around
+	poll := time.NewTicker(50 * time.Millisecond)
+	defer poll.Stop()
+	for groupAlive(p) {
+		select {
+		case <-poll.C:
+		case <-t.C:
+			c.wout.Event(EventKilling, "Child processes still running after %s, sending SIGKILL...\n", timeout)
+			killGroup(p)
+			return
+		}
+	}
+}
+
+// exitStatus returns the exit code and the signal (if any) that
+// ended the process. The code is -1 if the process didn't exit
+// normally.
+func exitStatus(ps *os.ProcessState) (int, os.Signal) {
+	if ps == nil {
+		return -1, nil
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, ws.Signal()
+	}
+	return ps.ExitCode(), nil
+}
+
+func (c *Command) setExitStatus(code int, sig os.Signal) {
+	c.Lock()
+	defer c.Unlock()
+	c.exitCode = code
+	c.exitSignal = sig
+}
+
+// ExitStatus returns the exit code and the signal (if any) that ended
+// the command's last run. The code is -1 if the command hasn't exited
+// or didn't exit normally.
+func (c *Command) ExitStatus() (int, os.Signal) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.exitCode, c.exitSignal
+}
+
+func (c *Command) setCancel(cancel context.CancelFunc) {
+	c.Lock()
+	defer c.Unlock()
+	c.cancel = cancel
+}
+
+func (c *Command) isStopped() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.stopped
+}
+
+// Cancel stops the command and prevents it from being restarted.
 func (c *Command) Cancel() {
-	c.setStatus(CmdStopped)
+	c.Lock()
+	defer c.Unlock()
+	switch c.status {
+	case CmdNotStarted, CmdRunning, CmdReady:
+		c.status = CmdStopped
+	}
+	c.stopped = true
+	c.stopping.fire()
 	if c.cancel != nil {
 		c.cancel()
 	}
 }
 
+// claim marks the command as being run by a manager, resetting it
+// if it has been run before. It returns false if the command is
+// already being run.
+func (c *Command) claim() bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.active {
+		return false
+	}
+	c.active = true
+	c.idle = make(chan struct{})
+
+	// Reset the command if it has already run
+	select {
+	case <-c.exited.done():
+		c.exited = newEvent()
+		c.stopping = newEvent()
+		c.stopped = false
+	default:
+	}
+	return true
+}
+
+// release marks the command as no longer being run by a manager.
+func (c *Command) release() {
+	c.Lock()
+	defer c.Unlock()
+	c.active = false
+	close(c.idle)
+}
+
+func (c *Command) isActive() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.active
+}
+
+// waitIdle waits until the manager is done running the command.
+func (c *Command) waitIdle() {
+	c.RLock()
+	idle := c.idle
+	c.RUnlock()
+	if idle != nil {
+		<-idle
+	}
+}
+
+func (c *Command) exitedEvent() *event {
+	c.RLock()
+	defer c.RUnlock()
+	return c.exited
+}
+
+func (c *Command) stoppingEvent() *event {
+	c.RLock()
+	defer c.RUnlock()
+	return c.stopping
+}
+
+// setProc records the running process (or clears it, if p is nil).
+func (c *Command) setProc(p *os.Process) {
+	c.Lock()
+	defer c.Unlock()
+	c.proc = p
+	if p == nil && !c.startedAt.IsZero() {
+		c.lastRun = time.Since(c.startedAt)
+	}
+	c.startedAt = time.Time{}
+	pid := 0
+	if p != nil {
+		c.startedAt = time.Now()
+		pid = p.Pid
+	}
+
+	// Show the process's ID in its output
+	for _, w := range []*PrefixWriter{c.wout, c.werr} {
+		if w != nil {
+			w.setPID(pid)
+		}
+	}
+}
+
+// PID returns the process ID of the command's current run (or 0
+// if it isn't running).
+func (c *Command) PID() int {
+	c.RLock()
+	defer c.RUnlock()
+	if c.proc == nil {
+		return 0
+	}
+	return c.proc.Pid
+}
+
+// Signal sends a signal to the command's running process (and the
+// rest of its process group).
+func (c *Command) Signal(sig os.Signal) error {
+	c.RLock()
+	p := c.proc
+	c.RUnlock()
+	if p == nil {
+		return fmt.Errorf("process %q isn't running", c.Name())
+	}
+	return signalGroup(p, sig)
+}
+
+// StartedAt returns when the command's current run started. It's
+// zero if the command isn't running.
+func (c *Command) StartedAt() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.startedAt
+}
+
+// skip marks a command that will never be run (e.g. because
+// one of its dependencies failed). If err is nil the command
+// is marked as stopped, otherwise it's marked as failed.
+func (c *Command) skip(err error) {
+	if err != nil {
+		c.setError(err)
+		c.setStatus(CmdFailed)
+	} else {
+		c.setStatus(CmdStopped)
+	}
+	c.exitedEvent().fire()
+}
+
 func (c *Command) setError(err error) {
 	c.Lock()
 	defer c.Unlock()
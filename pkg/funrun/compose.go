@@ -0,0 +1,333 @@
+package funrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeKeys are the service keys that can be converted
+// to a process config.
+var composeKeys = map[string]bool{
+	"command":     true,
+	"entrypoint":  true,
+	"environment": true,
+	"env_file":    true,
+	"working_dir": true,
+	"depends_on":  true,
+	"restart":     true,
+}
+
+// composeDependConditions maps compose's depends_on
+// conditions to dependency conditions.
+var composeDependConditions = map[string]DependCondition{
+	"service_started":                DependStarted,
+	"service_healthy":                DependReady,
+	"service_completed_successfully": DependCompleted,
+}
+
+// composeCmd is a compose command (or entrypoint), written
+// either as a string or as a list of words.
+type composeCmd struct {
+	shell string   // Set if written as a string
+	words []string // Set if written as a list
+}
+
+func (c *composeCmd) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		c.shell = value.Value
+		return nil
+	}
+	return value.Decode(&c.words)
+}
+
+func (c composeCmd) isSet() bool {
+	return c.shell != "" || len(c.words) > 0
+}
+
+// String returns the command as shell text.
+func (c composeCmd) String() string {
+	if c.shell != "" {
+		return c.shell
+	}
+	quoted := make([]string, len(c.words))
+	for i, w := range c.words {
+		quoted[i] = shellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes a word for the shell, if it needs it.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == '.' || r == '/' || r == '=' || r == ':' || r == ',' ||
+			r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// composeList is a compose value written either as a
+// single string or as a list of strings.
+type composeList []string
+
+func (l *composeList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = composeList{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(l))
+}
+
+// composeEnv is a compose environment, written either as a map or
+// as a list of "KEY=value" strings. Variables without a value are
+// passed through from fun-run's environment, so they're left out.
+type composeEnv map[string]string
+
+func (e *composeEnv) UnmarshalYAML(value *yaml.Node) error {
+	*e = composeEnv{}
+	if value.Kind == yaml.SequenceNode {
+		var items []string
+		if err := value.Decode(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if k, v, ok := strings.Cut(item, "="); ok {
+				(*e)[k] = v
+			}
+		}
+		return nil
+	}
+
+	var m map[string]*string
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if v != nil {
+			(*e)[k] = *v
+		}
+	}
+	return nil
+}
+
+// composeDeps is a compose depends_on, written either as a list of
+// service names or as a map of service names to conditions.
+type composeDeps []Dependency
+
+func (d *composeDeps) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			*d = append(*d, Dependency{Name: name})
+		}
+		return nil
+	}
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: depends_on must be a list or a map", value.Line)
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		var dep struct {
+			Condition string `yaml:"condition"`
+		}
+		if err := value.Content[i+1].Decode(&dep); err != nil {
+			return err
+		}
+		cond := DependStarted
+		if dep.Condition != "" {
+			var ok bool
+			if cond, ok = composeDependConditions[dep.Condition]; !ok {
+				return fmt.Errorf("line %d: unknown depends_on condition %q", value.Content[i+1].Line, dep.Condition)
+			}
+		}
+		*d = append(*d, Dependency{Name: value.Content[i].Value, Condition: cond})
+	}
+	return nil
+}
+
+// composeService is the part of a compose service that can be
+// converted to a process config.
+type composeService struct {
+	Command     composeCmd  `yaml:"command"`
+	Entrypoint  composeCmd  `yaml:"entrypoint"`
+	Environment composeEnv  `yaml:"environment"`
+	EnvFile     composeList `yaml:"env_file"`
+	WorkingDir  string      `yaml:"working_dir"`
+	DependsOn   composeDeps `yaml:"depends_on"`
+	Restart     string      `yaml:"restart"`
+}
+
+// composeRestart converts a compose restart policy.
+func composeRestart(s string) (RestartPolicy, bool) {
+	switch {
+	case s == "", s == "no":
+		return RestartNever, true
+	case s == "always", s == "unless-stopped":
+		return RestartAlways, true
+	case s == "on-failure", strings.HasPrefix(s, "on-failure:"):
+		return RestartOnFail, true
+	}
+	return "", false
+}
+
+// ComposeConf converts the services in a docker-compose file into
+// a config, with one process per service. Env files are read relative
+// to dir. Along with the config, it returns warnings about the parts
+// of the file that couldn't be converted (e.g. images and ports).
+func ComposeConf(b []byte, dir string) (*Conf, []string, error) {
+	var doc struct {
+		Services yaml.Node `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Services.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("no services found")
+	}
+
+	// Warn about the top-level keys that are ignored...
+	var warnings []string
+	var top map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &top); err != nil {
+		return nil, nil, err
+	}
+	var ignored []string
+	for k := range top {
+		if k != "services" && k != "version" && k != "name" {
+			ignored = append(ignored, k)
+		}
+	}
+	sort.Strings(ignored)
+	for _, k := range ignored {
+		warnings = append(warnings, fmt.Sprintf("ignoring unsupported key %q", k))
+	}
+
+	// Convert each service (in the order they're written)...
+	var conf Conf
+	nodes := doc.Services.Content
+	for i := 0; i+1 < len(nodes); i += 2 {
+		name, node := nodes[i].Value, nodes[i+1]
+		warn := func(format string, a ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf("service %q: ", name)+fmt.Sprintf(format, a...))
+		}
+
+		// Warn about the keys that can't be converted...
+		if node.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if k := node.Content[j].Value; !composeKeys[k] {
+					warn("ignoring unsupported key %q", k)
+				}
+			}
+		}
+
+		var svc composeService
+		if err := node.Decode(&svc); err != nil {
+			return nil, nil, fmt.Errorf("service %q: %w", name, err)
+		}
+		p := &ProcConf{
+			Name:      name,
+			DependsOn: svc.DependsOn,
+		}
+
+		// The working directory is usually a path in the container,
+		// so it's only kept if it exists here too...
+		if wd := svc.WorkingDir; wd != "" {
+			if !filepath.IsAbs(wd) {
+				wd = filepath.Join(dir, wd)
+			}
+			if fi, err := os.Stat(wd); err == nil && fi.IsDir() {
+				p.WorkDir = svc.WorkingDir
+			} else {
+				warn("ignoring working_dir %q (it doesn't exist outside of the container)", svc.WorkingDir)
+			}
+		}
+
+		// Convert the command. A list runs the program directly, and
+		// anything else runs with the shell.
+		switch {
+		case !svc.Command.isSet() && !svc.Entrypoint.isSet():
+			warn("skipped (no command or entrypoint)")
+			continue
+		case svc.Entrypoint.isSet() && svc.Entrypoint.shell == "" && svc.Command.shell == "":
+			words := append(append([]string{}, svc.Entrypoint.words...), svc.Command.words...)
+			p.Cmd, p.Args = words[0], words[1:]
+		case !svc.Entrypoint.isSet() && svc.Command.shell == "":
+			p.Cmd, p.Args = svc.Command.words[0], svc.Command.words[1:]
+		default:
+			text := svc.Entrypoint.String()
+			if svc.Command.isSet() {
+				text = strings.TrimSpace(text + " " + svc.Command.String())
+			}
+			p.Cmds = []string{text}
+		}
+
+		// Convert the restart policy...
+		restart, ok := composeRestart(svc.Restart)
+		if !ok {
+			return nil, nil, fmt.Errorf("service %q: unknown restart policy %q", name, svc.Restart)
+		}
+		if restart != RestartNever {
+			p.Restart = restart
+		}
+		if strings.HasPrefix(svc.Restart, "on-failure:") {
+			warn("ignoring the max retries in restart policy %q (see max_restarts)", svc.Restart)
+		}
+
+		// Merge the env files and environment (which takes precedence)...
+		envs := make(map[string]string)
+		for _, f := range svc.EnvFile {
+			if !filepath.IsAbs(f) {
+				f = filepath.Join(dir, f)
+			}
+			fenvs, err := ReadDotEnv(f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("service %q: %w", name, err)
+			}
+			for k, v := range fenvs {
+				envs[k] = v
+			}
+		}
+		for k, v := range svc.Environment {
+			envs[k] = v
+		}
+		if len(envs) > 0 {
+			p.Envs = envs
+		}
+
+		conf.Procs = append(conf.Procs, p)
+	}
+
+	// Drop dependencies on services that were skipped...
+	names := make(map[string]bool)
+	for _, p := range conf.Procs {
+		names[p.Name] = true
+	}
+	for _, p := range conf.Procs {
+		var deps []Dependency
+		for _, d := range p.DependsOn {
+			if !names[d.Name] {
+				warnings = append(warnings, fmt.Sprintf("service %q: dropping dependency on skipped service %q", p.Name, d.Name))
+				continue
+			}
+			deps = append(deps, d)
+
+			// ...and point out that healthchecks aren't converted, so
+			// without a ready check a healthy dependency is one that
+			// has just started
+			if d.Condition == DependReady {
+				warnings = append(warnings, fmt.Sprintf("service %q: waiting for %q to be healthy needs a ready check for %q (its healthcheck isn't converted, so for now it's ready once it starts)", p.Name, d.Name, d.Name))
+			}
+		}
+		p.DependsOn = deps
+	}
+
+	return &conf, warnings, nil
+}
@@ -0,0 +1,260 @@
+package funrun
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComposeCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  string   // The service's YAML
+		cmd  string   // Expected cmd
+		args []string // Expected args
+		cmds []string // Expected cmds
+	}{
+		{
+			name: "shell command",
+			svc:  `command: npm run dev -- --port 3000`,
+			cmds: []string{"npm run dev -- --port 3000"},
+		},
+		{
+			name: "list command",
+			svc:  `command: ["npm", "run", "dev"]`,
+			cmd:  "npm",
+			args: []string{"run", "dev"},
+		},
+		{
+			name: "list entrypoint",
+			svc:  `entrypoint: ["python", "-m", "app"]`,
+			cmd:  "python",
+			args: []string{"-m", "app"},
+		},
+		{
+			name: "list entrypoint and command",
+			svc:  "entrypoint: [python, -m]\ncommand: [app, --debug]",
+			cmd:  "python",
+			args: []string{"-m", "app", "--debug"},
+		},
+		{
+			name: "shell entrypoint and list command",
+			svc:  "entrypoint: python -m\ncommand: [app, \"two words\", \"it's\"]",
+			cmds: []string{`python -m app 'two words' 'it'\''s'`},
+		},
+		{
+			name: "list entrypoint and shell command",
+			svc:  "entrypoint: [docker-entrypoint.sh]\ncommand: postgres -c fsync=off",
+			cmds: []string{"docker-entrypoint.sh postgres -c fsync=off"},
+		},
+		{
+			name: "shell entrypoint",
+			svc:  "entrypoint: ./start.sh",
+			cmds: []string{"./start.sh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := mustComposeConf(t, "services:\n  svc:\n"+indent(tt.svc, "    "))
+			if len(conf.Procs) != 1 {
+				t.Fatalf("got %d processes, want 1", len(conf.Procs))
+			}
+			p := conf.Procs[0]
+			if p.Cmd != tt.cmd || !reflect.DeepEqual(p.Args, tt.args) || !reflect.DeepEqual(p.Cmds, tt.cmds) {
+				t.Errorf("got cmd %q, args %q, cmds %q; want cmd %q, args %q, cmds %q",
+					p.Cmd, p.Args, p.Cmds, tt.cmd, tt.args, tt.cmds)
+			}
+		})
+	}
+}
+
+func TestComposeEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "common.env", "SHARED=from-file\nOVERRIDDEN=from-file\n")
+	writeFile(t, dir, "extra.env", "EXTRA=1\n")
+
+	tests := []struct {
+		name string
+		svc  string
+		want map[string]string
+	}{
+		{
+			name: "map",
+			svc:  "environment:\n  A: one\n  B: \"2\"\n  PASSED_THROUGH:",
+			want: map[string]string{"A": "one", "B": "2"},
+		},
+		{
+			name: "list",
+			svc:  "environment:\n  - A=one\n  - B=x=y\n  - PASSED_THROUGH",
+			want: map[string]string{"A": "one", "B": "x=y"},
+		},
+		{
+			name: "env files, overridden by environment",
+			svc:  "env_file: [common.env, extra.env]\nenvironment:\n  OVERRIDDEN: from-env",
+			want: map[string]string{"SHARED": "from-file", "OVERRIDDEN": "from-env", "EXTRA": "1"},
+		},
+		{
+			name: "single env file",
+			svc:  "env_file: extra.env",
+			want: map[string]string{"EXTRA": "1"},
+		},
+		{
+			name: "none",
+			svc:  "",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, _, err := ComposeConf([]byte("services:\n  svc:\n    command: run\n"+indent(tt.svc, "    ")), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conf.Procs[0].Envs; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeRestartAndDeps(t *testing.T) {
+	conf, warnings, err := ComposeConf([]byte(`
+version: "3"
+services:
+  db:
+    image: postgres
+    command: postgres
+    restart: unless-stopped
+  cache:
+    image: redis
+  api:
+    command: ./api
+    restart: on-failure:3
+    ports: ["8080:8080"]
+    depends_on:
+      db:
+        condition: service_healthy
+      cache:
+        condition: service_started
+  worker:
+    command: ./worker
+    restart: "no"
+    depends_on: [api]
+volumes:
+  data:
+`), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Services without a command are skipped (along with the
+	// dependencies on them)...
+	var names []string
+	for _, p := range conf.Procs {
+		names = append(names, p.Name)
+	}
+	if got := strings.Join(names, " "); got != "db api worker" {
+		t.Fatalf("processes = %q, want %q", got, "db api worker")
+	}
+	db, api, worker := conf.Procs[0], conf.Procs[1], conf.Procs[2]
+
+	// ...restart policies are converted...
+	for _, c := range []struct {
+		p    *ProcConf
+		want RestartPolicy
+	}{{db, RestartAlways}, {api, RestartOnFail}, {worker, ""}} {
+		if c.p.Restart != c.want {
+			t.Errorf("%s: restart = %q, want %q", c.p.Name, c.p.Restart, c.want)
+		}
+	}
+
+	// ...and so are dependencies
+	if want := []Dependency{{Name: "db", Condition: DependReady}}; !reflect.DeepEqual(api.DependsOn, want) {
+		t.Errorf("api depends_on = %v, want %v", api.DependsOn, want)
+	}
+	if want := []Dependency{{Name: "api"}}; !reflect.DeepEqual(worker.DependsOn, want) {
+		t.Errorf("worker depends_on = %v, want %v", worker.DependsOn, want)
+	}
+
+	// Everything that was dropped is warned about
+	for _, w := range []string{
+		`ignoring unsupported key "volumes"`,
+		`service "db": ignoring unsupported key "image"`,
+		`service "cache": skipped (no command or entrypoint)`,
+		`service "api": ignoring unsupported key "ports"`,
+		`service "api": ignoring the max retries in restart policy "on-failure:3"`,
+		`service "api": dropping dependency on skipped service "cache"`,
+		`service "api": waiting for "db" to be healthy needs a ready check for "db"`,
+	} {
+		found := false
+		for _, got := range warnings {
+			if strings.Contains(got, w) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing warning %q in %q", w, warnings)
+		}
+	}
+}
+
+func TestComposeWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "web/index.html", "")
+	host := t.TempDir()
+
+	tests := []struct {
+		name string
+		wd   string
+		want string // The workdir ("" if it's dropped)
+	}{
+		{name: "container path", wd: "/app-in-the-container"},
+		{name: "missing relative path", wd: "src"},
+		{name: "relative path", wd: "web", want: "web"},
+		{name: "host path", wd: host, want: host},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, warnings, err := ComposeConf([]byte("services:\n  svc:\n    command: run\n    working_dir: "+tt.wd+"\n"), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conf.Procs[0].WorkDir; got != tt.want {
+				t.Errorf("workdir = %q, want %q", got, tt.want)
+			}
+			warned := strings.Contains(strings.Join(warnings, "\n"), "ignoring working_dir")
+			if warned != (tt.want == "") {
+				t.Errorf("warnings = %q, want a warning only if working_dir is dropped", warnings)
+			}
+		})
+	}
+}
+
+func TestComposeErrors(t *testing.T) {
+	for name, b := range map[string]string{
+		"no services":       "version: '3'",
+		"unknown restart":   "services:\n  a:\n    command: x\n    restart: sometimes",
+		"unknown condition": "services:\n  a:\n    command: x\n    depends_on:\n      b:\n        condition: service_happy",
+	} {
+		if _, _, err := ComposeConf([]byte(b), t.TempDir()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func mustComposeConf(t *testing.T, b string) *Conf {
+	t.Helper()
+	conf, _, err := ComposeConf([]byte(b), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+// indent indents each line of s.
+func indent(s, prefix string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix) + "\n"
+}
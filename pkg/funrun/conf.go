@@ -1,9 +1,11 @@
 package funrun
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"reflect"
+	"strconv"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -18,79 +20,419 @@ const (
 	RestartAlways RestartPolicy = "always"  // Always restart the process
 )
 
+// FailurePolicy represents what the manager does when
+// one of its processes fails.
+type FailurePolicy string
+
+const (
+	OnFailureContinue FailurePolicy = "continue" // Keep running the other processes
+	OnFailureStopAll  FailurePolicy = "stop-all" // Stop all of the other processes
+)
+
+// Duration is a length of time that can be written in a config file
+// either as a duration string (e.g. "30s" or "5m") or as a plain
+// number of seconds.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// parseDuration parses a duration string or a number of seconds.
+func parseDuration(s string) (Duration, error) {
+	// Is it a plain number of seconds?
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f < 0 {
+			return 0, fmt.Errorf("duration %q can't be negative", s)
+		}
+		return Duration(f * float64(time.Second)), nil
+	}
+
+	// Otherwise, parse it as a duration string...
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (expected something like \"30s\" or \"5m\")", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q can't be negative", s)
+	}
+	return Duration(d), nil
+}
+
+// UnmarshalYAML parses a duration. Invalid durations are reported as
+// type errors, so that the rest of the config is still decoded.
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: expected a duration", n.Line)}}
+	}
+	v, err := parseDuration(n.Value)
+	if err != nil {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %v", n.Line, err)}}
+	}
+	*d = v
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		return d.UnmarshalText([]byte(v))
+	case float64:
+		return d.UnmarshalText(b)
+	}
+	return fmt.Errorf("expected a duration, got %s", b)
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := parseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// DependCondition represents the point in a dependency's lifecycle
+// that a dependent process waits for before starting.
+type DependCondition string
+
+const (
+	DependStarted   DependCondition = "started"   // Wait for the dependency to start
+	DependReady     DependCondition = "ready"     // Wait for the dependency to pass its readiness checks
+	DependCompleted DependCondition = "completed" // Wait for the dependency to finish successfully
+)
+
+// Dependency is a reference to another process that must reach
+// a given condition before the dependent process can start.
+//
+// In yaml, a dependency can be written either as just the name of
+// the process (using the default "started" condition) or as a map
+// with "name" and "condition" keys.
+type Dependency struct {
+	Name      string          `json:"name" yaml:"name"`                               // Name of the process to depend on
+	Condition DependCondition `json:"condition,omitempty" yaml:"condition,omitempty"` // Condition to wait for (default: started)
+}
+
+func (d *Dependency) UnmarshalYAML(n *yaml.Node) error {
+	// Is it just the name?
+	if n.Kind == yaml.ScalarNode {
+		d.Name = n.Value
+		return nil
+	}
+
+	// Otherwise, decode the full form...
+	type plain Dependency
+	return n.Decode((*plain)(d))
+}
+
+func (d Dependency) MarshalYAML() (any, error) {
+	// Use the short form if possible...
+	if d.Condition == "" || d.Condition == DependStarted {
+		return d.Name, nil
+	}
+
+	type plain Dependency
+	return plain(d), nil
+}
+
+func (d *Dependency) UnmarshalJSON(b []byte) error {
+	// Is it just the name?
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		d.Name = name
+		return nil
+	}
+
+	type plain Dependency
+	return json.Unmarshal(b, (*plain)(d))
+}
+
+func (d Dependency) MarshalJSON() ([]byte, error) {
+	// Use the short form if possible...
+	if d.Condition == "" || d.Condition == DependStarted {
+		return json.Marshal(d.Name)
+	}
+
+	type plain Dependency
+	return json.Marshal(plain(d))
+}
+
+// StringList is a list of strings that can also be written
+// as a single string.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(n *yaml.Node) error {
+	// Is it a single string?
+	if n.Kind == yaml.ScalarNode {
+		*l = StringList{n.Value}
+		return nil
+	}
+
+	var ss []string
+	if err := n.Decode(&ss); err != nil {
+		return err
+	}
+	*l = ss
+	return nil
+}
+
+func (l *StringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*l = ss
+	return nil
+}
+
+// ProcConf configures a process.
 type ProcConf struct {
-	Name      string            `json:"name,omitempty" yaml:"name,omitempty"`             // Name of the process
-	Cmd       string            `json:"cmd,omitempty" yaml:"cmd,omitempty"`               // Command to run (required unless Cmds is set)
-	Cmds      []string          `json:"cmds,omitempty" yaml:"cmds,omitempty"`             // Command to run (required unless Cmd is set)
-	Args      []string          `json:"args,omitempty" yaml:"args,omitempty"`             // Arguments to pass to the command
-	Envs      map[string]string `json:"envs,omitempty" yaml:"envs,omitempty"`             // Environment variables to set
-	Restart   RestartPolicy     `json:"restart,omitempty" yaml:"restart,omitempty"`       // Restart policy for the command
-	WorkDir   string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`       // Working directory for the command
-	ClearEnvs bool              `json:"clear_envs,omitempty" yaml:"clear_envs,omitempty"` // Clear all environment variables before setting the ones in Envs
+	Name       string            `json:"name,omitempty" yaml:"name,omitempty"`                 // Name of the process
+	Extends    string            `json:"extends,omitempty" yaml:"extends,omitempty"`           // Name of a process to inherit settings from
+	Cmd        string            `json:"cmd,omitempty" yaml:"cmd,omitempty"`                   // Command to run (required unless Cmds is set)
+	Cmds       []string          `json:"cmds,omitempty" yaml:"cmds,omitempty"`                 // Command to run (required unless Cmd is set)
+	Args       []string          `json:"args,omitempty" yaml:"args,omitempty"`                 // Arguments to pass to the command
+	Envs       map[string]string `json:"envs,omitempty" yaml:"envs,omitempty"`                 // Environment variables to set
+	EnvFile    StringList        `json:"env_file,omitempty" yaml:"env_file,omitempty"`         // Env files to read variables from (relative to the config file; overridden by Envs)
+	Secrets    []string          `json:"secrets,omitempty" yaml:"secrets,omitempty"`           // Secrets (from the top-level secrets) to set as environment variables
+	Restart    RestartPolicy     `json:"restart,omitempty" yaml:"restart,omitempty"`           // Restart policy for the command
+	WorkDir    string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`           // Working directory for the command (relative to the config file; default: the config file's directory)
+	ClearEnvs  bool              `json:"clear_envs,omitempty" yaml:"clear_envs,omitempty"`     // Clear all environment variables before setting the ones in Envs
+	DependsOn  []Dependency      `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`     // Processes that must start (or finish) before this one
+	Ready      *ReadyConf        `json:"ready,omitempty" yaml:"ready,omitempty"`               // Checks used to decide when the process is ready
+	ExitOnExit bool              `json:"exit_on_exit,omitempty" yaml:"exit_on_exit,omitempty"` // Stop all processes (and exit with this process's exit code) when this process exits
+	Watch      *WatchConf        `json:"watch,omitempty" yaml:"watch,omitempty"`               // Restart the process when files change
+
+	SuccessExitCodes     []int `json:"success_exit_codes,omitempty" yaml:"success_exit_codes,omitempty"`             // Exit codes (in addition to 0) that count as success
+	RestartOnExitCodes   []int `json:"restart_on_exit_codes,omitempty" yaml:"restart_on_exit_codes,omitempty"`       // With "on-fail", only restart on these exit codes
+	NoRestartOnExitCodes []int `json:"no_restart_on_exit_codes,omitempty" yaml:"no_restart_on_exit_codes,omitempty"` // Never restart on these exit codes
 
-	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"` // Timeout for the command
+	RestartDelay    Duration `json:"restart_delay,omitempty" yaml:"restart_delay,omitempty"`         // Delay before restarting (doubled after each failure, or run shorter than 1s, in a row; default: 250ms after one)
+	RestartMaxDelay Duration `json:"restart_max_delay,omitempty" yaml:"restart_max_delay,omitempty"` // Max delay between restarts (default: 30s)
+	MaxRestarts     int      `json:"max_restarts,omitempty" yaml:"max_restarts,omitempty"`           // Max restarts within the restart window before giving up (default: no limit)
+	RestartWindow   Duration `json:"restart_window,omitempty" yaml:"restart_window,omitempty"`       // Window in which restarts are counted for max_restarts (default: 1m)
+
+	Timeout     Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`           // Max time each run of the command can take before it's stopped
+	StopSignal  string   `json:"stop_signal,omitempty" yaml:"stop_signal,omitempty"`   // Signal sent to ask the process to stop (default: SIGTERM)
+	StopTimeout Duration `json:"stop_timeout,omitempty" yaml:"stop_timeout,omitempty"` // Time to wait after sending the stop signal before killing the process (default: 10s)
 }
 
+// Conf is a fun-run config.
 type Conf struct {
-	Procs []*ProcConf `yaml:"procs"`
+	Procs     []*ProcConf            `json:"procs" yaml:"procs"`                               // Processes to run
+	Include   []string               `json:"include,omitempty" yaml:"include,omitempty"`       // Config files to merge this one on top of (relative to this one)
+	Profiles  map[string][]string    `json:"profiles,omitempty" yaml:"profiles,omitempty"`     // Named sets of processes that can be run together
+	OnFailure FailurePolicy          `json:"on_failure,omitempty" yaml:"on_failure,omitempty"` // What to do when a process fails (default: continue)
+	Socket    string                 `json:"socket,omitempty" yaml:"socket,omitempty"`         // Path of the control socket, relative to the config file (default: .fun-run.sock)
+	Envs      map[string]string      `json:"envs,omitempty" yaml:"envs,omitempty"`             // Environment variables to set for every process
+	EnvFile   StringList             `json:"env_file,omitempty" yaml:"env_file,omitempty"`     // Env files to read variables for every process from (relative to the config file; overridden by Envs)
+	Secrets   map[string]*SecretConf `json:"secrets,omitempty" yaml:"secrets,omitempty"`       // Secrets that processes can use as environment variables, by name
+	Log       *LogConf               `json:"log,omitempty" yaml:"log,omitempty"`               // Settings for fun-run's output
+}
+
+// ReadConf reads a config from one or more files ("-" for stdin). The
+// files (and the files they include) are deep-merged in order, with
+// processes merged by name. Each file's format comes from its
+// extension, and stdin is read as YAML.
+func ReadConf(paths ...string) (*Conf, error) {
+	return ReadConfFormat("", paths...)
 }
 
-func ReadConf(path string) (*Conf, error) {
-	if path == "" {
+// ReadConfFormat is like ReadConf, but reads stdin in the given
+// format (or as YAML, if it's empty).
+func ReadConfFormat(stdinFormat Format, paths ...string) (*Conf, error) {
+	if len(paths) == 0 {
 		return nil, fmt.Errorf("config file path not set")
 	}
-
-	// Open the file
-	f := os.Stdin
-
-	// If a path is given, open the file, otherwise use stdin
-	if path != "-" {
-		var err error
-		f, err = os.Open(path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open config file: %w", err)
+	for _, p := range paths {
+		if p == "" {
+			return nil, fmt.Errorf("config file path not set")
 		}
-		defer f.Close()
 	}
 
-	// Read the file
-	b, err := io.ReadAll(f)
+	// Read the files (and the files they include) and merge them
+	src, err := loadConfSource(paths, stdinFormat)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	// Parse the file
+	// Check for keys that don't exist (e.g. typos). Values of the
+	// wrong type were already found in each file...
+	v := &validator{src: src, problems: src.problems, unexpanded: make(map[*ProcConf]bool)}
+	v.checkKeys(src.root, reflect.TypeOf(Conf{}))
+
+	// ...and parse the merged config. Values of the wrong type are
+	// left unset, so the rest of it can still be checked. (Problems
+	// that were already found in one of the files aren't repeated.)
 	var conf Conf
-	err = yaml.Unmarshal(b, &conf)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if err := src.root.Decode(&conf); err != nil {
+		if len(src.problems) == 0 {
+			v.problems = append(v.problems, src.decodeProblems(err)...)
+		}
+		var te *yaml.TypeError
+		if !errors.As(err, &te) {
+			return nil, v.err()
+		}
+	}
+
+	// Name any processes that aren't named...
+	for i, p := range conf.Procs {
+		if p != nil && p.Name == "" {
+			p.Name = fmt.Sprintf("proc-%d", i)
+		}
+	}
+
+	// Combine each process's environment variables with the shared ones
+	// (and the ones from env files), and expand the variables in its
+	// config...
+	src.resolveEnvs(&conf, v)
+	src.expandProcs(&conf, v)
+
+	// Make the working directories (and secret files) relative to
+	// the config files...
+	src.resolveWorkDirs(&conf)
+	src.resolveSecretPaths(&conf)
+
+	// Check the failure policy...
+	switch conf.OnFailure {
+	case "":
+		conf.OnFailure = OnFailureContinue
+	case OnFailureContinue, OnFailureStopAll:
+	default:
+		v.add(getKey(src.root, "on_failure"), "invalid on_failure policy %q (must be %q or %q)", conf.OnFailure, OnFailureContinue, OnFailureStopAll)
+	}
+
+	// Set the control socket path (relative to the config files)...
+	conf.Socket = src.resolveSocket(conf.Socket)
+
+	// Check the log settings...
+	if conf.Log != nil {
+		if err := conf.Log.validate(); err != nil {
+			v.add(getKey(getKey(src.root, "log"), "format"), "%s", err)
+		}
 	}
 
 	// Validate and set defaults...
+	names := make(map[string]*yaml.Node)
 	for i, p := range conf.Procs {
 		if p == nil {
-			return nil, fmt.Errorf("process %d is nil", i)
+			v.add(v.procKey(i, ""), "process %d is empty", i)
+			continue
+		}
+
+		// Check that the name is unique...
+		if first, ok := names[p.Name]; ok {
+			v.add(v.procKey(i, "name"), "duplicate process name %q (first used at %s)", p.Name, src.pos(first))
+		} else {
+			names[p.Name] = v.procKey(i, "name")
 		}
 
 		// Check that a command is set...
 		if p.Cmd == "" && len(p.Cmds) == 0 {
-			return nil, fmt.Errorf("missing command for process %d", i)
+			v.add(v.procKey(i, ""), "missing command for process %q", p.Name)
 		}
 
 		// Check that both commands aren't set...
 		if p.Cmd != "" && len(p.Cmds) != 0 {
-			return nil, fmt.Errorf("can't set both 'cmd' and 'cmds' for process %d", i)
+			v.add(v.procKey(i, "cmds"), "can't set both 'cmd' and 'cmds' for process %q", p.Name)
 		}
 
-		// Set the default restart policy
-		if p.Restart == "" {
+		// Check the command can be found, and the working directory exists...
+		v.checkExecutable(i, p)
+		v.checkWorkDir(i, p)
+
+		// Check the restart policy (or set the default)...
+		switch p.Restart {
+		case "":
 			p.Restart = RestartNever
+		case RestartNever, RestartOnFail, RestartAlways:
+		default:
+			v.add(v.procKey(i, "restart"), "invalid restart policy %q for process %q (must be %q, %q or %q)",
+				p.Restart, p.Name, RestartNever, RestartOnFail, RestartAlways)
 		}
 
-		// Set a name if not set...
-		if p.Name == "" {
-			p.Name = fmt.Sprintf("proc-%d", i)
+		// Check the restart limits...
+		if p.MaxRestarts < 0 {
+			v.add(v.procKey(i, "max_restarts"), "max_restarts can't be negative for process %q", p.Name)
+		}
+
+		// Check the stop signal...
+		if p.StopSignal != "" {
+			if _, err := parseSignal(p.StopSignal); err != nil {
+				v.add(v.procKey(i, "stop_signal"), "invalid stop signal for process %q: %s", p.Name, err)
+			}
 		}
+
+		// Check the readiness config...
+		if p.Ready != nil {
+			if err := p.Ready.validate(); err != nil {
+				v.add(v.procKey(i, "ready"), "invalid readiness config for process %q: %s", p.Name, err)
+			}
+		}
+
+		// Check the watch config...
+		if p.Watch != nil {
+			if err := p.Watch.validate(); err != nil {
+				v.add(v.procKey(i, "watch"), "invalid watch config for process %q: %s", p.Name, err)
+			}
+		}
+
+		// Set the default dependency conditions...
+		for j, d := range p.DependsOn {
+			switch d.Condition {
+			case "":
+				p.DependsOn[j].Condition = DependStarted
+			case DependStarted, DependReady, DependCompleted:
+			default:
+				v.add(v.depNode(i, j), "invalid dependency condition %q for process %q (must be %q, %q or %q)",
+					d.Condition, p.Name, DependStarted, DependReady, DependCompleted)
+			}
+		}
+	}
+
+	// Check the dependencies...
+	for i, p := range conf.Procs {
+		if p == nil {
+			continue
+		}
+		for j, d := range p.DependsOn {
+			if _, ok := names[d.Name]; !ok {
+				v.add(v.depNode(i, j), "process %q depends on unknown process %q", p.Name, d.Name)
+			} else if d.Name == p.Name {
+				v.add(v.depNode(i, j), "process %q depends on itself", p.Name)
+			}
+		}
+	}
+
+	// Check the profiles and secrets...
+	conf.validateProfiles(v)
+	conf.validateSecrets(v)
+
+	// Check for dependency cycles (leaving out the problems
+	// that were already found)...
+	if _, err := conf.depGraph().procLevels(); err != nil {
+		v.add(getKey(src.root, "procs"), "%s", err)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
 	// Return the config successfully!
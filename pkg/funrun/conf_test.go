@@ -0,0 +1,98 @@
+package funrun
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes a file in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// readTestConf writes a config to a temporary directory and reads it.
+func readTestConf(t *testing.T, content string) (*Conf, error) {
+	t.Helper()
+	return ReadConf(writeFile(t, t.TempDir(), "fun-run.yaml", content))
+}
+
+// mustReadConf is readTestConf for configs that should be valid.
+func mustReadConf(t *testing.T, content string) *Conf {
+	t.Helper()
+	conf, err := readTestConf(t, content)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	return conf
+}
+
+func TestReadConfReportsEveryProblem(t *testing.T) {
+	_, err := readTestConf(t, `
+procs:
+  - name: a
+    cmd: echo
+    restat: always
+    restart: allways
+    workdir: ./nope
+    timeout: banana
+    depends_on: [ghost]
+  - name: a
+    cmd: echo
+    max_restarts: lots
+  - name: c
+    cmd: no-such-command-for-fun-run
+    depends_on: [d]
+  - name: d
+    cmd: echo
+    depends_on: [c]
+`)
+	var ce *ConfError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a ConfError, got %v", err)
+	}
+
+	want := []string{
+		`unknown key "restat"`,
+		`invalid restart policy "allways"`,
+		`nope" for process "a" doesn't exist`,
+		`invalid duration "banana"`,
+		`can't find command "no-such-command-for-fun-run"`,
+		`depends on unknown process "ghost"`,
+		`duplicate process name "a"`,
+		"cannot unmarshal !!str `lots` into int",
+		"dependency cycle detected: c -> d -> c",
+	}
+	for _, w := range want {
+		found := false
+		for _, p := range ce.Problems {
+			if strings.Contains(p.Message, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing problem %q in:\n%v", w, err)
+		}
+	}
+	if len(ce.Problems) != len(want) {
+		t.Errorf("got %d problems, want %d:\n%v", len(ce.Problems), len(want), err)
+	}
+
+	// Every problem is in the config file
+	for _, p := range ce.Problems {
+		if filepath.Base(p.File) != "fun-run.yaml" {
+			t.Errorf("problem %q is in %q, want fun-run.yaml", p.Message, p.File)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package funrun
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// DefaultSocket is the default path of the control socket.
+const DefaultSocket = ".fun-run.sock"
+
+// CtlOp is an operation that can be requested over
+// the control socket.
+type CtlOp string
+
+const (
+	CtlList    CtlOp = "list"    // List the status of every process
+	CtlStatus  CtlOp = "status"  // Get the status of one process
+	CtlStart   CtlOp = "start"   // Start a process
+	CtlStop    CtlOp = "stop"    // Stop a process
+	CtlRestart CtlOp = "restart" // Restart a process
+	CtlSignal  CtlOp = "signal"  // Send a signal to a process
+)
+
+// CtlRequest is a request sent to the control socket.
+type CtlRequest struct {
+	Op     CtlOp  `json:"op"`               // The operation to run
+	Name   string `json:"name,omitempty"`   // Name of the process (for everything but list)
+	Signal string `json:"signal,omitempty"` // Signal to send (for signal)
+}
+
+// CtlResponse is the control socket's response to a request.
+type CtlResponse struct {
+	OK    bool         `json:"ok"`              // Did the request succeed?
+	Error string       `json:"error,omitempty"` // Why the request failed
+	Procs []ProcStatus `json:"procs,omitempty"` // Status of the requested process(es)
+}
+
+// ProcStatus is a snapshot of a process's status.
+type ProcStatus struct {
+	Name      string     `json:"name"`                 // Name of the process
+	Status    string     `json:"status"`               // Current status
+	PID       int        `json:"pid,omitempty"`        // Process ID (if running)
+	StartedAt *time.Time `json:"started_at,omitempty"` // When the current run started (if running)
+	Restarts  int        `json:"restarts"`             // Number of times it has been restarted
+	ExitCode  *int       `json:"exit_code,omitempty"`  // Exit code of the last run (if it exited normally)
+	Signal    string     `json:"signal,omitempty"`     // Signal that ended the last run (if any)
+	Error     string     `json:"error,omitempty"`      // Error from the last run (if it isn't running)
+}
+
+// ProcStatus returns a snapshot of the command's status.
+func (c *Command) ProcStatus() ProcStatus {
+	ps := ProcStatus{
+		Name:     c.Name(),
+		Status:   c.Status().String(),
+		PID:      c.PID(),
+		Restarts: c.Restarts(),
+	}
+	if t := c.StartedAt(); !t.IsZero() {
+		ps.StartedAt = &t
+	}
+	code, sig := c.ExitStatus()
+	if code >= 0 {
+		ps.ExitCode = &code
+	}
+	if sig != nil {
+		ps.Signal = signalName(sig)
+	}
+	if err := c.Error(); err != nil && ps.PID == 0 {
+		ps.Error = err.Error()
+	}
+	return ps
+}
+
+// SetSocket sets the path of the unix socket the manager listens on
+// for control requests while it's running. An empty path disables
+// the socket.
+func (m *Manager) SetSocket(path string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.socket = path
+}
+
+// Signal sends a signal to a running command.
+func (m *Manager) Signal(name string, sig os.Signal) error {
+	cmd := m.getCmd(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown process %q", name)
+	}
+	return cmd.Signal(sig)
+}
+
+// listenCtl starts listening on the control socket. If a stale socket
+// file is left over from a previous run, it's removed first.
+func listenCtl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		// Is something still listening on it?
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %q is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serveCtl handles connections to the control socket until
+// the listener is closed.
+func (m *Manager) serveCtl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go m.handleCtlConn(conn)
+	}
+}
+
+// handleCtlConn handles the requests (one JSON object per line)
+// sent over a single control socket connection.
+func (m *Manager) handleCtlConn(conn net.Conn) {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var req CtlRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			enc.Encode(CtlResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+			continue
+		}
+		enc.Encode(m.handleCtl(req))
+	}
+}
+
+// handleCtl runs a single control request.
+func (m *Manager) handleCtl(req CtlRequest) CtlResponse {
+	// Get the process (for everything but list)...
+	var cmd *Command
+	if req.Op != CtlList {
+		if cmd = m.getCmd(req.Name); cmd == nil {
+			return CtlResponse{Error: fmt.Sprintf("unknown process %q", req.Name)}
+		}
+	}
+
+	var err error
+	switch req.Op {
+	case CtlList:
+		var res CtlResponse
+		for _, c := range m.Commands() {
+			res.Procs = append(res.Procs, c.ProcStatus())
+		}
+		res.OK = true
+		return res
+
+	case CtlStatus:
+	case CtlStart:
+		err = m.Start(req.Name)
+	case CtlStop:
+		err = m.Stop(req.Name)
+	case CtlRestart:
+		err = m.Restart(req.Name)
+	case CtlSignal:
+		var sig os.Signal
+		if sig, err = parseSignal(req.Signal); err == nil {
+			err = m.Signal(req.Name, sig)
+		}
+	default:
+		err = fmt.Errorf("unknown operation %q", req.Op)
+	}
+	if err != nil {
+		return CtlResponse{Error: err.Error()}
+	}
+	return CtlResponse{OK: true, Procs: []ProcStatus{cmd.ProcStatus()}}
+}
+
+// SendCtl sends a request to the control socket of a running
+// fun-run manager and returns its response.
+func SendCtl(path string, req CtlRequest) (*CtlResponse, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control socket (is fun-run running?): %w", err)
+	}
+	defer conn.Close()
+
+	// Send the request...
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	// ...and read the response
+	var res CtlResponse
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if !res.OK {
+		return &res, errors.New(res.Error)
+	}
+	return &res, nil
+}
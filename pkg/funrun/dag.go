@@ -0,0 +1,125 @@
+package funrun
+
+import (
+	"fmt"
+	"strings"
+)
+
+// procLevels sorts the config's processes into levels based on their
+// dependencies. Processes in level 0 have no dependencies, processes
+// in level 1 only depend on processes in level 0, and so on.
+//
+// An error is returned if a dependency doesn't exist or if the
+// dependencies form a cycle.
+func (c *Conf) procLevels() ([][]*ProcConf, error) {
+	// Index the processes by name...
+	byName := make(map[string]*ProcConf, len(c.Procs))
+	for _, p := range c.Procs {
+		if _, ok := byName[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate process name %q", p.Name)
+		}
+		byName[p.Name] = p
+	}
+
+	// Check that all of the dependencies exist...
+	for _, p := range c.Procs {
+		for _, d := range p.DependsOn {
+			if _, ok := byName[d.Name]; !ok {
+				return nil, fmt.Errorf("process %q depends on unknown process %q", p.Name, d.Name)
+			}
+			if d.Name == p.Name {
+				return nil, fmt.Errorf("process %q depends on itself", p.Name)
+			}
+		}
+	}
+
+	// Walk the graph, finding each process's depth and
+	// checking for cycles along the way...
+	depths := make(map[string]int, len(c.Procs))
+	visiting := make(map[string]bool)
+	var path []string
+
+	var visit func(p *ProcConf) (int, error)
+	visit = func(p *ProcConf) (int, error) {
+		if d, ok := depths[p.Name]; ok {
+			return d, nil
+		}
+		if visiting[p.Name] {
+			// Find where the cycle starts and report it...
+			start := 0
+			for i, n := range path {
+				if n == p.Name {
+					start = i
+				}
+			}
+			cycle := append(path[start:], p.Name)
+			return 0, fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+
+		visiting[p.Name] = true
+		path = append(path, p.Name)
+
+		var depth int
+		for _, d := range p.DependsOn {
+			dd, err := visit(byName[d.Name])
+			if err != nil {
+				return 0, err
+			}
+			if dd+1 > depth {
+				depth = dd + 1
+			}
+		}
+
+		path = path[:len(path)-1]
+		visiting[p.Name] = false
+		depths[p.Name] = depth
+		return depth, nil
+	}
+
+	// Group the processes by depth (preserving config order)...
+	var levels [][]*ProcConf
+	for _, p := range c.Procs {
+		d, err := visit(p)
+		if err != nil {
+			return nil, err
+		}
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+	}
+	for _, p := range c.Procs {
+		d := depths[p.Name]
+		levels[d] = append(levels[d], p)
+	}
+	return levels, nil
+}
+
+// depGraph returns a copy of the config with just the processes and
+// dependencies that procLevels can check, so that cycles can be found
+// even when there are other problems. Empty processes, duplicate names,
+// and dependencies on unknown processes (or on themselves) are left out.
+func (c *Conf) depGraph() *Conf {
+	seen := make(map[string]bool, len(c.Procs))
+	for _, p := range c.Procs {
+		if p != nil {
+			seen[p.Name] = true
+		}
+	}
+
+	g := &Conf{}
+	added := make(map[string]bool, len(c.Procs))
+	for _, p := range c.Procs {
+		if p == nil || added[p.Name] {
+			continue
+		}
+		added[p.Name] = true
+		gp := &ProcConf{Name: p.Name}
+		for _, d := range p.DependsOn {
+			if seen[d.Name] && d.Name != p.Name {
+				gp.DependsOn = append(gp.DependsOn, d)
+			}
+		}
+		g.Procs = append(g.Procs, gp)
+	}
+	return g
+}
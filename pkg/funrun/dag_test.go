@@ -0,0 +1,126 @@
+package funrun
+
+import (
+	"strings"
+	"testing"
+)
+
+// depConf builds a config from "name: dep dep..." specs.
+func depConf(specs ...string) *Conf {
+	var c Conf
+	for _, s := range specs {
+		name, deps, _ := strings.Cut(s, ":")
+		p := &ProcConf{Name: strings.TrimSpace(name)}
+		for _, d := range strings.Fields(deps) {
+			p.DependsOn = append(p.DependsOn, Dependency{Name: d})
+		}
+		c.Procs = append(c.Procs, p)
+	}
+	return &c
+}
+
+// levelNames returns the names of the processes in each level.
+func levelNames(levels [][]*ProcConf) string {
+	var ls []string
+	for _, l := range levels {
+		var names []string
+		for _, p := range l {
+			names = append(names, p.Name)
+		}
+		ls = append(ls, strings.Join(names, " "))
+	}
+	return strings.Join(ls, " | ")
+}
+
+func TestProcLevels(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		want  string // Levels, as "a b | c | d"
+		err   string // Part of the error, if there should be one
+	}{
+		{
+			name:  "no dependencies",
+			specs: []string{"a:", "b:", "c:"},
+			want:  "a b c",
+		},
+		{
+			name:  "chain",
+			specs: []string{"c: b", "b: a", "a:"},
+			want:  "a | b | c",
+		},
+		{
+			name:  "diamond",
+			specs: []string{"app: api worker", "api: db", "worker: db queue", "db:", "queue:"},
+			want:  "db queue | api worker | app",
+		},
+		{
+			name:  "level is the longest path",
+			specs: []string{"a:", "b: a", "c: a b"},
+			want:  "a | b | c",
+		},
+		{
+			name:  "config order within a level",
+			specs: []string{"z:", "y: z", "x:", "w: z"},
+			want:  "z x | y w",
+		},
+		{
+			name:  "self-dependency",
+			specs: []string{"a: a"},
+			err:   `process "a" depends on itself`,
+		},
+		{
+			name:  "unknown dependency",
+			specs: []string{"a: ghost"},
+			err:   `process "a" depends on unknown process "ghost"`,
+		},
+		{
+			name:  "duplicate name",
+			specs: []string{"a:", "a:"},
+			err:   `duplicate process name "a"`,
+		},
+		{
+			name:  "two-process cycle",
+			specs: []string{"a: b", "b: a"},
+			err:   "dependency cycle detected: a -> b -> a",
+		},
+		{
+			name:  "cycle after a valid prefix",
+			specs: []string{"start: a", "a: b", "b: c", "c: a"},
+			err:   "dependency cycle detected: a -> b -> c -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levels, err := depConf(tt.specs...).procLevels()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("procLevels() error = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("procLevels() returned an error: %v", err)
+			}
+			if got := levelNames(levels); got != tt.want {
+				t.Errorf("procLevels() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDepGraph(t *testing.T) {
+	// Problems that are reported elsewhere are left out, so that
+	// cycles can still be found
+	c := depConf("a: a ghost b", "b: a", "b:")
+	c.Procs = append(c.Procs, nil)
+	_, err := c.depGraph().procLevels()
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("procLevels() error = %v, want the a -> b -> a cycle", err)
+	}
+
+	// ...and the original config isn't changed
+	if n := len(c.Procs[0].DependsOn); n != 3 {
+		t.Errorf("a has %d dependencies, want 3", n)
+	}
+}
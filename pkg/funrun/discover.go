@@ -0,0 +1,104 @@
+package funrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfEnvVar is the environment variable that can be set to the
+// path of the config file to use when none is given.
+const ConfEnvVar = "FUN_RUN_CONFIG"
+
+// ConfFileNames are the names of the config files that FindConf looks
+// for, in the order they're tried within each directory.
+var ConfFileNames = []string{"fun-run.yaml", "fun-run.yml", "fun-run.json", "fun-run.toml"}
+
+// FindConf returns the path of the config file to use when none is
+// given. That's the path in FUN_RUN_CONFIG (if it's set), or else the
+// first config file found in dir or one of its parents.
+func FindConf(dir string) (string, error) {
+	if p := os.Getenv(ConfEnvVar); p != "" {
+		return p, nil
+	}
+
+	start, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the absolute path of %q: %w", dir, err)
+	}
+	for d := start; ; d = filepath.Dir(d) {
+		for _, name := range ConfFileNames {
+			p := filepath.Join(d, name)
+			if info, err := os.Stat(p); err == nil && !info.IsDir() {
+				// Keep the path relative to where the search
+				// started, if possible (e.g. "../fun-run.yaml")
+				if rel, err := filepath.Rel(start, p); err == nil {
+					return filepath.Join(dir, rel), nil
+				}
+				return p, nil
+			}
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return "", fmt.Errorf("no config file found in %s or its parents (looked for %s; set %s or pass a path to use a different file)",
+		start, strings.Join(ConfFileNames, ", "), ConfEnvVar)
+}
+
+// resolveWorkDirs makes the processes' working directories relative
+// to the config files rather than to the current directory. A relative
+// workdir is relative to the file it was set in, and processes without
+// one run in the directory of the first config file.
+func (s *confSource) resolveWorkDirs(c *Conf) {
+	for i, p := range c.Procs {
+		if p == nil {
+			continue
+		}
+		if p.WorkDir == "" {
+			p.WorkDir = s.dir
+			continue
+		}
+		if filepath.IsAbs(p.WorkDir) {
+			continue
+		}
+		if n := getKey(s.procNode(i), "workdir"); n != nil {
+			if f, ok := s.files[n]; ok && f != stdinName {
+				p.WorkDir = filepath.Join(filepath.Dir(f), p.WorkDir)
+			}
+		}
+	}
+}
+
+// resolveSocket makes the control socket's path relative to the config
+// file it was set in (or, by default, to the first config file), so
+// that it doesn't depend on where fun-run is run from.
+func (s *confSource) resolveSocket(socket string) string {
+	if socket == "" {
+		return filepath.Join(s.dir, DefaultSocket)
+	}
+	if filepath.IsAbs(socket) {
+		return socket
+	}
+	if f, ok := s.files[getKey(s.root, "socket")]; ok && f != stdinName {
+		return filepath.Join(filepath.Dir(f), socket)
+	}
+	return filepath.Join(s.dir, socket)
+}
+
+// SocketPath returns the path of the control socket for a config (as
+// ReadConf would set it), without checking the rest of the config.
+func SocketPath(paths ...string) (string, error) {
+	src, err := loadConfSource(paths, "")
+	if err != nil {
+		return "", err
+	}
+	var socket string
+	if n := getKey(src.root, "socket"); n != nil {
+		if err := n.Decode(&socket); err != nil {
+			return "", fmt.Errorf("%s: invalid socket: %w", src.pos(n), err)
+		}
+	}
+	return src.resolveSocket(socket), nil
+}
@@ -0,0 +1,131 @@
+package funrun
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSocketPath(t *testing.T) {
+	abs := filepath.Join(t.TempDir(), "abs.sock")
+	tests := []struct {
+		name  string
+		files map[string]string // Config files, by path (the first is read)
+		want  string            // Path of the socket, relative to the directory
+	}{
+		{
+			name:  "default",
+			files: map[string]string{"fun-run.yaml": "procs: [{cmd: echo}]"},
+			want:  DefaultSocket,
+		},
+		{
+			name:  "relative to the config file",
+			files: map[string]string{"fun-run.yaml": "socket: run/ctl.sock\nprocs: [{cmd: echo}]"},
+			want:  "run/ctl.sock",
+		},
+		{
+			name: "relative to an included file",
+			files: map[string]string{
+				"fun-run.yaml":   "include: [base/base.yaml]\nprocs: [{cmd: echo}]",
+				"base/base.yaml": "socket: ctl.sock",
+			},
+			want: "base/ctl.sock",
+		},
+		{
+			name:  "absolute",
+			files: map[string]string{"fun-run.yaml": "socket: " + abs + "\nprocs: [{cmd: echo}]"},
+			want:  abs,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			path := filepath.Join(dir, "fun-run.yaml")
+			want := tt.want
+			if !filepath.IsAbs(want) {
+				want = filepath.Join(dir, want)
+			}
+
+			conf, err := ReadConf(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Socket != want {
+				t.Errorf("Socket = %q, want %q", conf.Socket, want)
+			}
+			socket, err := SocketPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if socket != want {
+				t.Errorf("SocketPath() = %q, want %q", socket, want)
+			}
+		})
+	}
+}
+
+func TestSocketPathFromSubdir(t *testing.T) {
+	// "fun-run run" in a subdirectory and "fun-run ctl" in the
+	// project's root should agree on where the socket is
+	dir := t.TempDir()
+	writeFile(t, dir, "fun-run.yaml", "procs: [{cmd: echo}]")
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fromSub, err := FindConf(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromRoot, err := FindConf(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := SocketPath(fromSub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SocketPath(fromRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Clean(a) != filepath.Clean(b) || filepath.Clean(a) != filepath.Join(dir, DefaultSocket) {
+		t.Errorf("socket from the subdirectory = %q, from the root = %q, want both %q", a, b, filepath.Join(dir, DefaultSocket))
+	}
+}
+
+func TestFindConf(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The nearest config wins...
+	t.Setenv(ConfEnvVar, "")
+	writeFile(t, dir, "fun-run.toml", "")
+	writeFile(t, dir, "a/fun-run.json", "{}")
+	p, err := FindConf(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(sub, "..", "fun-run.json"); filepath.Clean(p) != filepath.Clean(want) {
+		t.Errorf("FindConf() = %q, want %q", p, want)
+	}
+
+	// ...and YAML comes first within a directory...
+	writeFile(t, dir, "a/fun-run.yml", "")
+	if p, err = FindConf(sub); err != nil || filepath.Base(p) != "fun-run.yml" {
+		t.Errorf("FindConf() = %q, %v, want fun-run.yml", p, err)
+	}
+
+	// ...unless FUN_RUN_CONFIG is set
+	t.Setenv(ConfEnvVar, "elsewhere.yaml")
+	if p, err = FindConf(sub); err != nil || p != "elsewhere.yaml" {
+		t.Errorf("FindConf() = %q, %v, want %q", p, err, "elsewhere.yaml")
+	}
+}
@@ -0,0 +1,178 @@
+package funrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveEnvs sets each process's Envs to the variables fun-run sets
+// for it. From lowest to highest precedence, those are the variables
+// from the top-level env files, the top-level envs, the variables from
+// the process's own env files, and the process's own envs.
+//
+// The values are expanded as they're resolved. They can refer to the
+// other variables in the same layer, and to the value a variable has
+// in the layers below (e.g. "PATH: ./bin:${PATH}").
+func (s *confSource) resolveEnvs(c *Conf, v *validator) {
+	files := make(map[string]map[string]string)
+	reported := make(map[string]bool)
+	for i, p := range c.Procs {
+		if p == nil {
+			continue
+		}
+
+		// Get the layers...
+		var layers []envLayer
+		layers = s.envFileLayers(layers, c.EnvFile, getKey(s.root, "env_file"), files, v)
+		layers = append(layers, envLayer{vals: c.Envs, node: getKey(s.root, "envs")})
+		layers = s.envFileLayers(layers, p.EnvFile, v.procKey(i, "env_file"), files, v)
+		layers = append(layers, envLayer{vals: p.Envs, node: v.procKey(i, "envs")})
+
+		// ...and resolve them in order
+		envs := make(map[string]string)
+		for _, l := range layers {
+			for k, err := range l.resolve(envs, s.procLookup(p, envs)) {
+				n := l.node
+				if kn := getKey(n, k); kn != nil {
+					n = kn
+				}
+				if key := s.pos(n) + err.Error(); !reported[key] {
+					reported[key] = true
+					v.add(n, "invalid value for env %q: %v", k, err)
+				}
+			}
+		}
+		p.Envs = nil
+		if len(envs) > 0 {
+			p.Envs = envs
+		}
+	}
+}
+
+// envLayer is a set of variables that override the ones before it.
+type envLayer struct {
+	vals map[string]string // The variables (before they're expanded)
+	node *yaml.Node        // Where they were set
+}
+
+// envFileLayers adds a layer for each env file (relative to the
+// config file that lists it). The files are only read once.
+func (s *confSource) envFileLayers(layers []envLayer, paths []string, n *yaml.Node, files map[string]map[string]string, v *validator) []envLayer {
+	for _, p := range paths {
+		if f, ok := s.files[n]; ok && f != stdinName && !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(f), p)
+		}
+		vals, ok := files[p]
+		if !ok {
+			if _, err := os.Stat(p); err != nil {
+				v.add(n, "env_file %q doesn't exist", p)
+			} else if vals, err = ReadDotEnv(p); err != nil {
+				v.add(n, "%v", err)
+			}
+			files[p] = vals
+		}
+		layers = append(layers, envLayer{vals: vals, node: n})
+	}
+	return layers
+}
+
+// resolve expands the layer's variables and adds them to envs,
+// returning the variables that couldn't be expanded. Variables that
+// aren't in the layer are looked up with lower.
+func (l envLayer) resolve(envs map[string]string, lower lookupFunc) map[string]error {
+	out := make(map[string]string, len(l.vals))
+	errs := make(map[string]error)
+	var stack []string
+
+	var get func(name string) (string, error)
+	get = func(name string) (string, error) {
+		if v, ok := out[name]; ok {
+			return v, nil
+		}
+		if err, ok := errs[name]; ok {
+			return "", err
+		}
+		for _, st := range stack {
+			if st == name {
+				return "", fmt.Errorf("env cycle detected: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+		stack = append(stack, name)
+		defer func() { stack = stack[:len(stack)-1] }()
+
+		v, err := expand(l.vals[name], func(ref string) (string, bool, error) {
+			// A variable that refers to itself gets the value from
+			// the layers below
+			if _, ok := l.vals[ref]; ok && ref != name {
+				v, err := get(ref)
+				return v, true, err
+			}
+			return lower(ref)
+		})
+		if err != nil {
+			errs[name] = err
+			return "", err
+		}
+		out[name] = v
+		return v, nil
+	}
+
+	keys := make([]string, 0, len(l.vals))
+	for k := range l.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		get(k)
+	}
+	for k, v := range out {
+		envs[k] = v
+	}
+	return errs
+}
+
+// Environ returns the environment a process is run with, as sorted
+// "KEY=value" strings. That's the variables fun-run sets for it
+// (including its secrets), on top of fun-run's own environment
+// (unless ClearEnvs is set). The secrets' values aren't included,
+// and are shown as "<secret NAME>" instead.
+func (p *ProcConf) Environ() []string {
+	envs := p.environ()
+	for i, e := range envs {
+		envs[i] = showSecrets(e)
+	}
+	return envs
+}
+
+// environ is like Environ, but leaves the placeholders for
+// secrets in place.
+func (p *ProcConf) environ() []string {
+	envs := make(map[string]string)
+	if !p.ClearEnvs {
+		for _, e := range os.Environ() {
+			if k, val, ok := strings.Cut(e, "="); ok && k != "" {
+				envs[k] = val
+			}
+		}
+	}
+	for k, val := range p.Envs {
+		envs[k] = val
+	}
+
+	// The secrets take precedence
+	for _, name := range p.Secrets {
+		envs[name] = secretRef(name)
+	}
+
+	list := make([]string, 0, len(envs))
+	for k, val := range envs {
+		list = append(list, k+"="+val)
+	}
+	sort.Strings(list)
+	return list
+}
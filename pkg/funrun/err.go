@@ -1,13 +1,49 @@
 package funrun
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
 
 type MapError map[string]error
 
 func (m MapError) Error() string {
+	// Sort the names so the output is stable...
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
 	var s string
-	for k, v := range m {
-		s += fmt.Sprintf("(%s) %s\n", k, v.Error())
+	for _, k := range names {
+		s += fmt.Sprintf("(%s) %s\n", k, m[k].Error())
 	}
 	return s
 }
+
+// TimeoutError is the error recorded when a command runs for
+// longer than its configured timeout.
+type TimeoutError struct {
+	Timeout time.Duration // The timeout that was exceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// ExitError is the error recorded when a command exits
+// unsuccessfully.
+type ExitError struct {
+	Code   int       // The exit code (or -1 if the process was killed by a signal)
+	Signal os.Signal // The signal that killed the process (if any)
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("killed by %s", signalName(e.Signal))
+	}
+	return fmt.Sprintf("exited with code %d", e.Code)
+}
@@ -0,0 +1,241 @@
+package funrun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in variables that can be used in a process's config.
+const (
+	VarProcName  = "FUNRUN_PROC_NAME"  // Name of the process
+	VarConfigDir = "FUNRUN_CONFIG_DIR" // Absolute path of the (first) config file's directory
+)
+
+// lookupFunc returns the value of a variable, and whether it's set.
+type lookupFunc func(name string) (string, bool, error)
+
+// expand replaces the variables in a string, like the shell does:
+//
+//	$VAR, ${VAR}     The value of VAR (or "" if it isn't set)
+//	${VAR:-default}  The default if VAR is unset or empty
+//	${VAR-default}   The default if VAR is unset
+//	${VAR:+other}    The other value if VAR is set and not empty
+//	${VAR+other}     The other value if VAR is set
+//	${VAR:?message}  An error if VAR is unset or empty
+//	${VAR?message}   An error if VAR is unset
+//	$$               A literal "$"
+//
+// Defaults, other values and messages are expanded too. A "$" that
+// isn't followed by a name (e.g. "$1" or "$@") is left as it is.
+func expand(s string, lookup lookupFunc) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		switch c := s[i+1]; {
+		case c == '$':
+			b.WriteByte('$')
+			i += 2
+
+		case c == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference %q", s[i:])
+			}
+			val, err := expandBraced(s[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+			i = end + 1
+
+		case isNameStart(c):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			val, _, err := lookup(s[i+1 : j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+			i = j
+
+		default:
+			b.WriteByte('$')
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// expandBraced expands the inside of a "${...}" reference.
+func expandBraced(ref string, lookup lookupFunc) (string, error) {
+	n := 0
+	if n < len(ref) && isNameStart(ref[n]) {
+		for n < len(ref) && isNameChar(ref[n]) {
+			n++
+		}
+	}
+	name, op := ref[:n], ref[n:]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable reference %q", "${"+ref+"}")
+	}
+	val, ok, err := lookup(name)
+	if err != nil {
+		return "", err
+	}
+	if op == "" {
+		return val, nil
+	}
+
+	// Does the operator also apply to empty values?
+	set := ok
+	if strings.HasPrefix(op, ":") {
+		set = ok && val != ""
+		op = op[1:]
+	}
+	if op == "" {
+		return "", fmt.Errorf("invalid variable reference %q", "${"+ref+"}")
+	}
+	word := op[1:]
+	switch op[0] {
+	case '-':
+		if set {
+			return val, nil
+		}
+		return expand(word, lookup)
+
+	case '+':
+		if !set {
+			return "", nil
+		}
+		return expand(word, lookup)
+
+	case '?':
+		if set {
+			return val, nil
+		}
+		msg, err := expand(word, lookup)
+		if err != nil {
+			return "", err
+		}
+		if msg == "" {
+			msg = "not set"
+		}
+		return "", fmt.Errorf("%s: %s", name, msg)
+	}
+	return "", fmt.Errorf("invalid variable reference %q", "${"+ref+"}")
+}
+
+// closingBrace returns the index of the "}" that closes a "${"
+// (allowing for nested references), or -1.
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || '0' <= c && c <= '9'
+}
+
+// confDir returns the absolute path of the first config
+// file's directory (or of the current directory).
+func (s *confSource) confDir() string {
+	dir := s.dir
+	if dir == "" {
+		dir = "."
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// procLookup returns a lookup for the variables that can be used in a
+// process's config: the built-in variables, the process's secrets
+// (which are only filled in when it's run), the variables set in vars,
+// and then (unless the process clears it) fun-run's environment.
+func (s *confSource) procLookup(p *ProcConf, vars map[string]string) lookupFunc {
+	builtins := map[string]string{
+		VarProcName:  p.Name,
+		VarConfigDir: s.confDir(),
+	}
+	for _, name := range p.Secrets {
+		builtins[name] = secretRef(name)
+	}
+	return func(name string) (string, bool, error) {
+		if v, ok := builtins[name]; ok {
+			return v, true, nil
+		}
+		if v, ok := vars[name]; ok {
+			return v, true, nil
+		}
+		if p.ClearEnvs {
+			return "", false, nil
+		}
+		v, ok := os.LookupEnv(name)
+		return v, ok, nil
+	}
+}
+
+// expandProcs expands the variables in each process's command,
+// arguments and working directory (after its environment has been
+// resolved, so they can use its variables). Values that can't be
+// expanded are left as they are.
+func (s *confSource) expandProcs(c *Conf, v *validator) {
+	for i, p := range c.Procs {
+		if p == nil {
+			continue
+		}
+		lookup := s.procLookup(p, p.Envs)
+		field := func(key string, idx int, val string) string {
+			out, err := expand(val, lookup)
+			if err != nil {
+				n := v.procKey(i, key)
+				if n != nil && n.Kind == yaml.SequenceNode && idx < len(n.Content) {
+					n = n.Content[idx]
+				}
+				v.add(n, "invalid %s for process %q: %v", key, p.Name, err)
+				v.unexpanded[p] = true
+				return val
+			}
+			return out
+		}
+
+		p.Cmd = field("cmd", 0, p.Cmd)
+		for j := range p.Cmds {
+			p.Cmds[j] = field("cmds", j, p.Cmds[j])
+		}
+		for j := range p.Args {
+			p.Args[j] = field("args", j, p.Args[j])
+		}
+		p.WorkDir = field("workdir", 0, p.WorkDir)
+	}
+}
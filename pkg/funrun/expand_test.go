@@ -0,0 +1,294 @@
+package funrun
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mapLookup looks variables up in a map.
+func mapLookup(vars map[string]string) lookupFunc {
+	return func(name string) (string, bool, error) {
+		v, ok := vars[name]
+		return v, ok, nil
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{
+		"NAME":  "fun-run",
+		"EMPTY": "",
+		"OTHER": "NAME",
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string // Part of the error, if there should be one
+	}{
+		// Plain references
+		{in: "no vars", want: "no vars"},
+		{in: "$NAME", want: "fun-run"},
+		{in: "${NAME}", want: "fun-run"},
+		{in: "hi $NAME!", want: "hi fun-run!"},
+		{in: "${NAME}s", want: "fun-runs"},
+		{in: "$NAMEs", want: ""},
+		{in: "$UNSET", want: ""},
+
+		// Defaults
+		{in: "${UNSET:-dflt}", want: "dflt"},
+		{in: "${EMPTY:-dflt}", want: "dflt"},
+		{in: "${NAME:-dflt}", want: "fun-run"},
+		{in: "${UNSET-dflt}", want: "dflt"},
+		{in: "${EMPTY-dflt}", want: ""},
+		{in: "${UNSET:-$NAME}", want: "fun-run"},
+
+		// Alternatives
+		{in: "${NAME:+alt}", want: "alt"},
+		{in: "${EMPTY:+alt}", want: ""},
+		{in: "${UNSET:+alt}", want: ""},
+		{in: "${EMPTY+alt}", want: "alt"},
+		{in: "${UNSET+alt}", want: ""},
+
+		// Required values
+		{in: "${NAME:?missing}", want: "fun-run"},
+		{in: "${EMPTY?missing}", want: ""},
+		{in: "${EMPTY:?missing}", err: "EMPTY: missing"},
+		{in: "${UNSET?missing}", err: "UNSET: missing"},
+		{in: "${UNSET:?}", err: "UNSET: not set"},
+		{in: "${UNSET:?no $NAME}", err: "UNSET: no fun-run"},
+
+		// Escapes and literal "$"s
+		{in: "$$NAME", want: "$NAME"},
+		{in: "$${NAME}", want: "${NAME}"},
+		{in: "$$$NAME", want: "$fun-run"},
+		{in: "cost: 5$", want: "cost: 5$"},
+		{in: "$1 $@ $-", want: "$1 $@ $-"},
+
+		// Nested references
+		{in: "${UNSET:-${EMPTY:-${NAME}}}", want: "fun-run"},
+		{in: "${UNSET:-{braces}}", want: "{braces}"},
+		{in: "${UNSET:-a$$b}", want: "a$b"},
+
+		// Invalid references
+		{in: "${NAME", err: "unterminated"},
+		{in: "${UNSET:-${NAME}", err: "unterminated"},
+		{in: "${}", err: "invalid variable reference"},
+		{in: "${1}", err: "invalid variable reference"},
+		{in: "${NAME:}", err: "invalid variable reference"},
+		{in: "${NAME/a/b}", err: "invalid variable reference"},
+	}
+	for _, tt := range tests {
+		got, err := expand(tt.in, mapLookup(vars))
+		switch {
+		case tt.err != "" && err == nil:
+			t.Errorf("expand(%q) = %q, want an error containing %q", tt.in, got, tt.err)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("expand(%q) error = %q, want it to contain %q", tt.in, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("expand(%q) returned an error: %v", tt.in, err)
+		case tt.err == "" && got != tt.want:
+			t.Errorf("expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEnvLayerResolve(t *testing.T) {
+	tests := []struct {
+		name  string
+		lower map[string]string // Variables from the layers below
+		vals  map[string]string // The layer's variables
+		want  map[string]string // The variables after resolving
+		errs  map[string]string // Part of the error for each variable that fails
+	}{
+		{
+			name: "plain values",
+			vals: map[string]string{"A": "1", "B": "2"},
+			want: map[string]string{"A": "1", "B": "2"},
+		},
+		{
+			name:  "self-reference reads the lower layer",
+			lower: map[string]string{"PATH": "/usr/bin"},
+			vals:  map[string]string{"PATH": "./bin:${PATH}"},
+			want:  map[string]string{"PATH": "./bin:/usr/bin"},
+		},
+		{
+			name: "self-reference without a lower value",
+			vals: map[string]string{"PATH": "./bin:${PATH}"},
+			want: map[string]string{"PATH": "./bin:"},
+		},
+		{
+			name: "references within the layer",
+			vals: map[string]string{
+				"HOST": "localhost",
+				"PORT": "8080",
+				"URL":  "http://$HOST:$PORT/${APP_PATH:-api}",
+			},
+			want: map[string]string{
+				"HOST": "localhost",
+				"PORT": "8080",
+				"URL":  "http://localhost:8080/api",
+			},
+		},
+		{
+			name:  "same-layer values override lower ones",
+			lower: map[string]string{"HOST": "lower", "PORT": "1"},
+			vals:  map[string]string{"HOST": "upper", "ADDR": "$HOST:$PORT"},
+			want:  map[string]string{"HOST": "upper", "ADDR": "upper:1"},
+		},
+		{
+			name: "chained references",
+			vals: map[string]string{"A": "$B", "B": "$C", "C": "c"},
+			want: map[string]string{"A": "c", "B": "c", "C": "c"},
+		},
+		{
+			name: "cycle",
+			vals: map[string]string{"A": "$B", "B": "$A", "C": "ok"},
+			want: map[string]string{"C": "ok"},
+			errs: map[string]string{
+				"A": "env cycle detected: A -> B -> A",
+				"B": "env cycle detected: A -> B -> A",
+			},
+		},
+		{
+			name: "longer cycle",
+			vals: map[string]string{"A": "${B}", "B": "${C:-x}", "C": "$A"},
+			want: map[string]string{},
+			errs: map[string]string{
+				"A": "env cycle detected: A -> B -> C -> A",
+				"B": "env cycle detected: A -> B -> C -> A",
+				"C": "env cycle detected: A -> B -> C -> A",
+			},
+		},
+		{
+			name: "invalid reference",
+			vals: map[string]string{"A": "${B", "C": "$A"},
+			want: map[string]string{},
+			errs: map[string]string{"A": "unterminated", "C": "unterminated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := make(map[string]string)
+			for k, v := range tt.lower {
+				envs[k] = v
+			}
+			errs := envLayer{vals: tt.vals}.resolve(envs, mapLookup(copyMap(envs)))
+
+			for k, want := range tt.want {
+				if got, ok := envs[k]; !ok || got != want {
+					t.Errorf("%s = %q (set: %v), want %q", k, got, ok, want)
+				}
+			}
+			for k := range tt.vals {
+				if _, ok := tt.want[k]; !ok {
+					if got, ok := envs[k]; ok && tt.lower[k] != got {
+						t.Errorf("%s = %q, want it not to be set", k, got)
+					}
+				}
+			}
+			for k, err := range errs {
+				want, ok := tt.errs[k]
+				if !ok {
+					t.Errorf("unexpected error for %s: %v", k, err)
+				} else if !strings.Contains(err.Error(), want) {
+					t.Errorf("error for %s = %q, want it to contain %q", k, err, want)
+				}
+			}
+			for k := range tt.errs {
+				if _, ok := errs[k]; !ok {
+					t.Errorf("expected an error for %s", k)
+				}
+			}
+		})
+	}
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func TestBuiltinVars(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "fun-run.yaml", `
+procs:
+  - name: api
+    cmd: echo
+    args: ["$FUNRUN_PROC_NAME", "${FUNRUN_CONFIG_DIR}/data"]
+    envs:
+      NAME: ${FUNRUN_PROC_NAME}-server
+`)
+	conf, err := ReadConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := conf.Procs[0]
+	if got := p.Args[0]; got != "api" {
+		t.Errorf("$FUNRUN_PROC_NAME = %q, want %q", got, "api")
+	}
+	if got, want := p.Args[1], abs+"/data"; got != want {
+		t.Errorf("${FUNRUN_CONFIG_DIR}/data = %q, want %q", got, want)
+	}
+	if got := p.Envs["NAME"]; got != "api-server" {
+		t.Errorf("NAME = %q, want %q", got, "api-server")
+	}
+}
+
+func TestProcLookupClearEnvs(t *testing.T) {
+	t.Setenv("FUNRUN_TEST_OUTER", "outer")
+
+	src := &confSource{dir: "."}
+	vars := map[string]string{"INNER": "inner"}
+	for _, clear := range []bool{false, true} {
+		p := &ProcConf{Name: "proc", ClearEnvs: clear, Secrets: []string{"TOKEN"}}
+		lookup := src.procLookup(p, vars)
+
+		// Fun-run's environment is only used without clear_envs...
+		v, ok, _ := lookup("FUNRUN_TEST_OUTER")
+		if clear && (ok || v != "") {
+			t.Errorf("clear_envs: FUNRUN_TEST_OUTER = %q (set: %v), want it unset", v, ok)
+		}
+		if !clear && (!ok || v != "outer") {
+			t.Errorf("FUNRUN_TEST_OUTER = %q (set: %v), want %q", v, ok, "outer")
+		}
+
+		// ...but the process's own variables, the built-in ones
+		// and its secrets always are
+		for name, want := range map[string]string{
+			"INNER":     "inner",
+			VarProcName: "proc",
+			"TOKEN":     secretRef("TOKEN"),
+		} {
+			if v, ok, _ := lookup(name); !ok || v != want {
+				t.Errorf("clear_envs=%v: %s = %q (set: %v), want %q", clear, name, v, ok, want)
+			}
+		}
+	}
+}
+
+func TestClearEnvsExpansion(t *testing.T) {
+	t.Setenv("FUNRUN_TEST_OUTER", "outer")
+	conf := mustReadConf(t, `
+procs:
+  - name: kept
+    cmd: echo
+    args: ["${FUNRUN_TEST_OUTER:-unset}"]
+  - name: cleared
+    cmd: echo
+    args: ["${FUNRUN_TEST_OUTER:-unset}"]
+    clear_envs: true
+`)
+	for i, want := range []string{"outer", "unset"} {
+		if got := conf.Procs[i].Args[0]; got != want {
+			t.Errorf("%s: arg = %q, want %q", conf.Procs[i].Name, got, want)
+		}
+	}
+}
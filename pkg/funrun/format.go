@@ -0,0 +1,215 @@
+package funrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a config file format.
+type Format string
+
+const (
+	FormatYAML Format = "yaml" // YAML (the default)
+	FormatJSON Format = "json" // JSON
+	FormatTOML Format = "toml" // TOML
+)
+
+// Formats are the supported config file formats.
+var Formats = []Format{FormatYAML, FormatJSON, FormatTOML}
+
+// ParseFormat parses the name of a config file format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "yml":
+		return FormatYAML, nil
+	case FormatYAML, FormatJSON, FormatTOML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown config format %q (must be yaml, json or toml)", s)
+}
+
+// FormatOf returns the format of a config file, based on its
+// extension. Anything that isn't JSON or TOML is read as YAML.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// MarshalConf encodes a config in the given format.
+func MarshalConf(c *Conf, f Format) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+
+	case FormatTOML:
+		// Go through yaml, so the keys (and the short forms
+		// of values) match the other formats
+		b, err := yaml.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return yaml.Marshal(c)
+}
+
+// parseNode parses a config file in the given format into a yaml
+// node, so that every format can be merged and checked the same way.
+func parseNode(name string, b []byte, f Format) (*yaml.Node, error) {
+	switch f {
+	case FormatJSON:
+		n, err := jsonNode(b)
+		if err != nil {
+			var se *json.SyntaxError
+			if errors.As(err, &se) {
+				// The offset is just after the byte that caused the error
+				line, col := lineCol(b, int(se.Offset)-1)
+				return nil, &ConfError{Problems: []Problem{{File: name, Line: line, Column: col, Message: se.Error()}}}
+			}
+			return nil, &ConfError{Problems: []Problem{{File: name, Message: err.Error()}}}
+		}
+		return n, nil
+
+	case FormatTOML:
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(b), &m); err != nil {
+			var pe toml.ParseError
+			if errors.As(err, &pe) {
+				line, col := lineCol(b, pe.Position.Start)
+				msg := tomlErrPrefix.ReplaceAllString(pe.Error(), "")
+				return nil, &ConfError{Problems: []Problem{{File: name, Line: line, Column: col, Message: msg}}}
+			}
+			return nil, &ConfError{Problems: []Problem{{File: name, Message: err.Error()}}}
+		}
+		var n yaml.Node
+		if err := n.Encode(m); err != nil {
+			return nil, err
+		}
+		return &n, nil
+	}
+
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, &ConfError{Problems: decodeProblems(name, nil, err)}
+	}
+	return &n, nil
+}
+
+// tomlErrPrefix matches the position at the start of toml's
+// error messages.
+var tomlErrPrefix = regexp.MustCompile(`^toml: line \d+( \(last key "[^"]*"\))?: `)
+
+// lineCol converts a byte offset into a line and column.
+func lineCol(b []byte, offset int) (int, int) {
+	if offset > len(b) {
+		offset = len(b)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	line := 1 + bytes.Count(b[:offset], []byte("\n"))
+	col := offset - bytes.LastIndexByte(b[:offset], '\n')
+	return line, col
+}
+
+// jsonNode parses JSON into a yaml node, keeping track of where
+// each value is so that problems can be reported by line.
+func jsonNode(b []byte) (*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	// next reads the next token, along with where it starts
+	next := func() (json.Token, int, int, error) {
+		start := int(dec.InputOffset())
+		for start < len(b) && strings.IndexByte(" \t\r\n,:", b[start]) >= 0 {
+			start++
+		}
+		tok, err := dec.Token()
+		line, col := lineCol(b, start)
+		return tok, line, col, err
+	}
+
+	var value func(tok json.Token, line, col int) (*yaml.Node, error)
+	value = func(tok json.Token, line, col int) (*yaml.Node, error) {
+		n := &yaml.Node{Line: line, Column: col}
+		switch t := tok.(type) {
+		case json.Delim:
+			n.Kind, n.Tag = yaml.MappingNode, "!!map"
+			end := json.Delim('}')
+			if t == '[' {
+				n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+				end = ']'
+			}
+			for {
+				tok, line, col, err := next()
+				if err != nil {
+					return nil, err
+				}
+				if tok == end {
+					return n, nil
+				}
+				c, err := value(tok, line, col)
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, c)
+			}
+		case string:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!str", t
+		case json.Number:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!int", t.String()
+			if strings.ContainsAny(t.String(), ".eE") {
+				n.Tag = "!!float"
+			}
+		case bool:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!bool", fmt.Sprint(t)
+		case nil:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!null", "null"
+		}
+		return n, nil
+	}
+
+	tok, line, col, err := next()
+	if err == io.EOF {
+		return &yaml.Node{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := value(tok, line, col)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := next(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the config")
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+package funrun
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLineCol(t *testing.T) {
+	b := []byte("ab\ncd\n\nef")
+	tests := []struct {
+		offset    int
+		line, col int
+	}{
+		{0, 1, 1},
+		{1, 1, 2},
+		{2, 1, 3}, // The newline itself
+		{3, 2, 1},
+		{6, 3, 1},
+		{7, 4, 1},
+		{9, 4, 3}, // The end of the input
+		{99, 4, 3},
+	}
+	for _, tt := range tests {
+		if line, col := lineCol(b, tt.offset); line != tt.line || col != tt.col {
+			t.Errorf("lineCol(%d) = %d:%d, want %d:%d", tt.offset, line, col, tt.line, tt.col)
+		}
+	}
+}
+
+func TestJSONNodePositions(t *testing.T) {
+	b := []byte(`{
+  "procs": [
+    {"name": "web", "cmd": "serve"},
+    {
+      "name":"db",
+	  "args" : [ "-p",5432 ],
+      "envs": {"A": true, "B": null}
+    }
+  ]
+}`)
+	n, err := jsonNode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each node, as "value@line:col", in document order
+	var got []string
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		v := n.Value
+		switch n.Kind {
+		case yaml.MappingNode:
+			v = "{"
+		case yaml.SequenceNode:
+			v = "["
+		}
+		got = append(got, fmt.Sprintf("%s@%d:%d", v, n.Line, n.Column))
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	want := []string{
+		"{@1:1",
+		"procs@2:3", "[@2:12",
+		"{@3:5", "name@3:6", "web@3:14", "cmd@3:21", "serve@3:28",
+		"{@4:5",
+		"name@5:7", "db@5:14",
+		"args@6:4", "[@6:13", "-p@6:15", "5432@6:20",
+		"envs@7:7", "{@7:15", "A@7:16", "true@7:21", "B@7:27", "null@7:32",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got nodes\n  %s\nwant\n  %s", strings.Join(got, " "), strings.Join(want, " "))
+	}
+}
+
+func TestJSONNodeTags(t *testing.T) {
+	n, err := jsonNode([]byte(`["s", 1, 1.5, 2e3, true, null]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"!!str", "!!int", "!!float", "!!float", "!!bool", "!!null"}
+	for i, c := range n.Content {
+		if c.Tag != want[i] {
+			t.Errorf("%s: tag = %s, want %s", c.Value, c.Tag, want[i])
+		}
+	}
+}
+
+func TestJSONNodeEmpty(t *testing.T) {
+	n, err := jsonNode([]byte(" \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Kind != 0 {
+		t.Errorf("got a node of kind %d for empty input, want an empty node", n.Kind)
+	}
+}
+
+func TestJSONSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		json      string
+		line, col int
+	}{
+		{name: "missing comma", json: "{\n  \"procs\": []\n  \"socket\": \"x\"\n}", line: 3, col: 3},
+		{name: "bad value", json: "{\"procs\": [\n  nope\n]}", line: 2, col: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseNode("fun-run.json", []byte(tt.json), FormatJSON)
+			var ce *ConfError
+			if !errors.As(err, &ce) || len(ce.Problems) != 1 {
+				t.Fatalf("parseNode() error = %v, want a problem", err)
+			}
+			p := ce.Problems[0]
+			if p.File != "fun-run.json" || p.Line != tt.line || p.Column != tt.col {
+				t.Errorf("problem at %s:%d:%d, want fun-run.json:%d:%d", p.File, p.Line, p.Column, tt.line, tt.col)
+			}
+		})
+	}
+
+	// Data after the config isn't a syntax error, but is still an error
+	if _, err := parseNode("fun-run.json", []byte(`{} {}`), FormatJSON); err == nil {
+		t.Error("expected an error for data after the config")
+	}
+}
+
+func TestJSONConfProblemPositions(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "fun-run.json", `{
+  "procs": [
+    {
+      "name": "a",
+      "cmd": "echo",
+      "restart": "sometimes",
+      "max_restarts": "lots"
+    }
+  ]
+}`)
+	_, err := ReadConf(path)
+	var ce *ConfError
+	if !errors.As(err, &ce) {
+		t.Fatalf("ReadConf() error = %v, want a *ConfError", err)
+	}
+
+	// Problems found by fun-run point at the value, and type errors
+	// at the start of the line (as they do in YAML)
+	want := map[string][2]int{
+		`"sometimes"`: {6, 18},
+		"`lots`":      {7, 7},
+	}
+	for _, p := range ce.Problems {
+		if filepath.Base(p.File) != "fun-run.json" {
+			t.Errorf("problem %q is in %s, want fun-run.json", p.Message, p.File)
+		}
+		for s, pos := range want {
+			if !strings.Contains(p.Message, s) {
+				continue
+			}
+			if p.Line != pos[0] || p.Column != pos[1] {
+				t.Errorf("problem %q at %d:%d, want %d:%d", p.Message, p.Line, p.Column, pos[0], pos[1])
+			}
+			delete(want, s)
+		}
+	}
+	for s := range want {
+		t.Errorf("no problem reported for %s in %v", s, ce.Problems)
+	}
+}
@@ -0,0 +1,172 @@
+//go:build ignore
+
+// gendocs writes schema_docs.go, which holds the doc comments (and
+// the values of the string constants) of the config types, so that
+// the config schema's descriptions and enums match the code.
+//
+// Run it with "go generate" after changing the config types.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sources are the files that define the config types.
+var sources = []string{"conf.go", "log.go", "ready.go", "secret.go", "watch.go"}
+
+// docs holds the doc comments from the config types' source.
+type docs struct {
+	types  map[string]string            // Type name -> doc comment
+	fields map[string]map[string]string // Type name -> field name -> comment
+	enums  map[string][]string          // Type name -> constant values
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gendocs: ")
+	out := flag.String("o", "schema_docs.go", "file to write")
+	flag.Parse()
+
+	d := &docs{
+		types:  make(map[string]string),
+		fields: make(map[string]map[string]string),
+		enums:  make(map[string][]string),
+	}
+	fset := token.NewFileSet()
+	for _, name := range sources {
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.add(f); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	b, err := d.source()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// add adds the comments and constants from a file.
+func (d *docs) add(f *ast.File) error {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if _, ok := d.types[s.Name.Name]; ok {
+					return fmt.Errorf("type %s is defined twice", s.Name.Name)
+				}
+				d.types[s.Name.Name] = cleanDoc(gd.Doc.Text())
+				st, ok := s.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				fields := make(map[string]string)
+				for _, fld := range st.Fields.List {
+					text := fld.Comment.Text()
+					if text == "" {
+						text = fld.Doc.Text()
+					}
+					for _, name := range fld.Names {
+						fields[name.Name] = cleanDoc(text)
+					}
+				}
+				d.fields[s.Name.Name] = fields
+
+			case *ast.ValueSpec:
+				ident, ok := s.Type.(*ast.Ident)
+				if gd.Tok != token.CONST || !ok {
+					continue
+				}
+				for _, v := range s.Values {
+					lit, ok := v.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					val, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						return fmt.Errorf("%s: %v", lit.Value, err)
+					}
+					d.enums[ident.Name] = append(d.enums[ident.Name], val)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cleanDoc turns a comment into a description.
+func cleanDoc(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// source returns the (formatted) source of schema_docs.go.
+func (d *docs) source() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gendocs.go; DO NOT EDIT.\n\n")
+	b.WriteString("package funrun\n\n")
+	b.WriteString("// docs holds the doc comments from the config types' source.\n")
+	b.WriteString("var docs = &sourceDocs{\n")
+
+	b.WriteString("types: map[string]string{\n")
+	for _, t := range sortedKeys(d.types) {
+		fmt.Fprintf(&b, "%q: %q,\n", t, d.types[t])
+	}
+	b.WriteString("},\n")
+
+	b.WriteString("fields: map[string]map[string]string{\n")
+	for _, t := range sortedKeys(d.fields) {
+		fmt.Fprintf(&b, "%q: {\n", t)
+		for _, f := range sortedKeys(d.fields[t]) {
+			fmt.Fprintf(&b, "%q: %q,\n", f, d.fields[t][f])
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\n")
+
+	b.WriteString("enums: map[string][]string{\n")
+	for _, t := range sortedKeys(d.enums) {
+		fmt.Fprintf(&b, "%q: {", t)
+		for i, v := range d.enums[t] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%q", v)
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\n")
+
+	b.WriteString("}\n")
+	return format.Source(b.Bytes())
+}
+
+// sortedKeys returns a map's keys, in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
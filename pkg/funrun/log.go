@@ -0,0 +1,104 @@
+package funrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogFormat is the format fun-run's output is written in.
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text" // Colored lines, prefixed with the process's name
+	LogFormatJSON LogFormat = "json" // A JSON object (a LogEntry) per line
+)
+
+// ParseLogFormat parses the name of a log format.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(s); f {
+	case LogFormatText, LogFormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid log format %q (must be %q or %q)", s, LogFormatText, LogFormatJSON)
+}
+
+// LogConf configures how fun-run writes the processes' output.
+type LogConf struct {
+	Format     LogFormat `json:"format,omitempty" yaml:"format,omitempty"`         // Format of the output (default: text)
+	Align      bool      `json:"align,omitempty" yaml:"align,omitempty"`           // Pad the processes' names to the same width
+	Stream     bool      `json:"stream,omitempty" yaml:"stream,omitempty"`         // Show which stream (stdout or stderr) each line is from
+	Timestamps bool      `json:"timestamps,omitempty" yaml:"timestamps,omitempty"` // Show the time before each line
+	PID        bool      `json:"pid,omitempty" yaml:"pid,omitempty"`               // Show the process's ID in each line
+}
+
+// validate checks the log settings.
+func (l *LogConf) validate() error {
+	if l.Format != "" {
+		if _, err := ParseLogFormat(string(l.Format)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logConf returns the config's log settings, with the defaults set.
+func (c *Conf) logConf() LogConf {
+	var l LogConf
+	if c.Log != nil {
+		l = *c.Log
+	}
+	if l.Format == "" {
+		l.Format = LogFormatText
+	}
+	return l
+}
+
+// EventType is the type of a lifecycle event.
+type EventType string
+
+const (
+	EventStarting     EventType = "starting"      // A process is starting
+	EventStartFailed  EventType = "start-failed"  // A process couldn't be started
+	EventWaiting      EventType = "waiting"       // A process is waiting for its dependencies
+	EventNotStarting  EventType = "not-starting"  // A process won't start (e.g. a dependency failed)
+	EventReady        EventType = "ready"         // A process passed its readiness checks
+	EventReadyFailed  EventType = "ready-failed"  // A process failed its readiness checks
+	EventStopping     EventType = "stopping"      // A process has been asked to stop
+	EventKilling      EventType = "killing"       // A process didn't stop in time and is being killed
+	EventStopped      EventType = "stopped"       // A process was stopped
+	EventTimedOut     EventType = "timed-out"     // A process ran for longer than its timeout
+	EventFinished     EventType = "finished"      // A process finished (and won't be restarted)
+	EventRestarting   EventType = "restarting"    // A process is about to be restarted
+	EventGivingUp     EventType = "giving-up"     // A process restarted too often and won't be restarted again
+	EventChanged      EventType = "changed"       // A watched file changed
+	EventBuilding     EventType = "building"      // A process's build command is running
+	EventBuildFailed  EventType = "build-failed"  // A process's build command failed
+	EventShuttingDown EventType = "shutting-down" // fun-run is shutting down
+	EventLog          EventType = "log"           // Any other message from fun-run
+)
+
+// LogEntry is a line of output, or a lifecycle event, in the
+// JSON log format.
+type LogEntry struct {
+	Time     time.Time `json:"time"`
+	Proc     string    `json:"proc,omitempty"`   // Name of the process (if any)
+	Stream   string    `json:"stream,omitempty"` // "stdout" or "stderr" (for output)
+	Event    EventType `json:"event,omitempty"`  // Type of event (for events)
+	PID      int       `json:"pid,omitempty"`    // ID of the process (while it's running)
+	Restarts int       `json:"restarts"`         // Number of times the process has been restarted
+	Message  string    `json:"message"`
+}
+
+// writeEntry writes a log entry as a line of JSON.
+func writeEntry(w io.Writer, e LogEntry) error {
+	e.Message = strings.TrimRight(e.Message, "\r\n")
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
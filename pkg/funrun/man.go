@@ -6,24 +6,40 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Manager struct {
-	conf   *Conf
-	cancel context.CancelFunc
-	cmds   []*Command
-	lock   sync.RWMutex
-	wout   io.Writer
-	werr   io.Writer
+	conf      *Conf
+	cancel    context.CancelFunc
+	cmds      []*Command
+	exitCmd   *Command        // The command that caused the manager to stop (if any)
+	runErr    error           // The error that stopped Run before the commands were started (if any)
+	ctx       context.Context // The manager's run context
+	procCtx   context.Context // The context the commands run in
+	active    int             // Number of commands that are currently running
+	pending   int             // Number of commands that are being restarted
+	exits     chan struct{}   // Notified each time a command exits
+	closing   bool            // Is the manager shutting down?
+	keepAlive bool            // Keep running after all of the commands have exited?
+	socket    string          // Path of the control socket ("" to disable it)
+	hidden    []string        // Secret values to hide from the output
+	lock      sync.RWMutex
+	wout      io.Writer
+	werr      io.Writer
+	outs      func(name, stream string) io.Writer
 }
 
 func NewManager(conf *Conf) *Manager {
 	return &Manager{
-		conf: conf,
-		wout: &SyncWriter{Writer: os.Stdout},
-		werr: &SyncWriter{Writer: os.Stderr},
+		conf:   conf,
+		socket: conf.Socket,
+		wout:   &SyncWriter{Writer: os.Stdout},
+		werr:   &SyncWriter{Writer: os.Stderr},
+		exits:  make(chan struct{}, 1),
 	}
 }
 
@@ -34,11 +50,34 @@ func (m *Manager) SetOutputs(wout, werr io.Writer) {
 	m.werr = &SyncWriter{Writer: werr}
 }
 
-func (m *Manager) createCmds() []*Command {
+// SetProcOutputs sets a function used to get the writers each
+// process's (prefixed) output is written to, in place of the
+// shared outputs set with SetOutputs. The stream is either
+// "stdout" or "stderr".
+func (m *Manager) SetProcOutputs(f func(name, stream string) io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.outs = f
+}
+
+// SetKeepAlive sets whether Run should keep running after all of the
+// commands have exited (e.g. so they can be started again), rather
+// than returning. Either way, Run returns once the manager is shut
+// down.
+func (m *Manager) SetKeepAlive(keepAlive bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.keepAlive = keepAlive
+}
+
+func (m *Manager) createCmds(secrets map[string]string) []*Command {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	nw := m.conf.maxNameLength()
+	hidden := redactions(secrets)
+	m.hidden = hidden
+	lc := m.conf.logConf()
 
 	cmds := make([]*Command, len(m.conf.Procs))
 	for i, proc := range m.conf.Procs {
@@ -46,22 +85,37 @@ func (m *Manager) createCmds() []*Command {
 		cmd := NewCommand(proc)
 
 		// Set the outputs...
+		ow, ew := m.wout, m.werr
+		if m.outs != nil {
+			ow = m.outs(proc.Name, "stdout")
+			ew = m.outs(proc.Name, "stderr")
+		}
 		wout := NewPrefixWriter(
 			proc.Name,
 			"stdout",
 			nw,
 			i,
-			m.wout,
+			ow,
 		)
 		werr := NewPrefixWriter(
 			proc.Name,
 			"stderr",
 			nw,
 			i,
-			m.werr,
+			ew,
 		)
+		wout.SetRedactions(hidden)
+		werr.SetRedactions(hidden)
+		wout.SetLogConf(lc)
+		werr.SetLogConf(lc)
 		cmd.SetOutputs(wout, werr)
 
+		// Give it its secrets...
+		cmd.secrets = make(map[string]string, len(proc.Secrets))
+		for _, name := range proc.Secrets {
+			cmd.secrets[name] = secrets[name]
+		}
+
 		// Store the command
 		cmds[i] = cmd
 	}
@@ -73,40 +127,211 @@ func (m *Manager) Shutdown() {
 	m.Cancel()
 }
 
+// getCmd returns the command with the given name (or nil
+// if there isn't one).
+func (m *Manager) getCmd(name string) *Command {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, cmd := range m.cmds {
+		if cmd.Name() == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// waitForDeps blocks until all of the command's dependencies have
+// reached their required condition. It returns an error if a
+// dependency can never reach its condition, or if the context
+// is cancelled first.
+func (m *Manager) waitForDeps(ctx context.Context, cmd *Command) error {
+	if len(cmd.conf.DependsOn) == 0 {
+		return nil
+	}
+
+	// Log what we're waiting for...
+	names := make([]string, len(cmd.conf.DependsOn))
+	for i, d := range cmd.conf.DependsOn {
+		names[i] = d.Name
+	}
+	cmd.wout.Event(EventWaiting, "Waiting for %s...\n", strings.Join(names, ", "))
+
+	for _, d := range cmd.conf.DependsOn {
+		dep := m.getCmd(d.Name)
+		if dep == nil {
+			return fmt.Errorf("unknown dependency %q", d.Name)
+		}
+
+		switch d.Condition {
+		case DependCompleted:
+			select {
+			case <-dep.exitedEvent().done():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if dep.Status() != CmdDone {
+				return fmt.Errorf("dependency %q did not complete successfully", d.Name)
+			}
+
+		case DependReady:
+			if err := waitForEvent(ctx, dep.ready, dep.exitedEvent()); err != nil {
+				return fmt.Errorf("dependency %q never became ready: %w", d.Name, err)
+			}
+
+		default:
+			if err := waitForEvent(ctx, dep.started, dep.exitedEvent()); err != nil {
+				return fmt.Errorf("dependency %q failed to start: %w", d.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitForEvent waits for ev to fire. It returns an error if the
+// command exits before ev fires or if the context is cancelled.
+func waitForEvent(ctx context.Context, ev, exited *event) error {
+	select {
+	case <-ev.done():
+		return nil
+	case <-exited.done():
+		// Did it fire before the command exited?
+		select {
+		case <-ev.done():
+			return nil
+		default:
+			return fmt.Errorf("process exited")
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopInOrder stops the commands in reverse dependency order,
+// so that each command is stopped (and has exited) before any
+// of the commands it depends on are stopped.
+func (m *Manager) stopInOrder(levels [][]*ProcConf) {
+	for i := len(levels) - 1; i >= 0; i-- {
+		// Stop everything in this level...
+		var cmds []*Command
+		for _, p := range levels[i] {
+			if cmd := m.getCmd(p.Name); cmd != nil {
+				cmd.Cancel()
+				cmds = append(cmds, cmd)
+			}
+		}
+
+		// ...and wait for it to exit before moving on
+		for _, cmd := range cmds {
+			<-cmd.exitedEvent().done()
+		}
+	}
+}
+
 func (m *Manager) Run(ctx context.Context) error {
 	// Create the parent context
 	ctx, cancel := context.WithCancel(ctx)
-	m.cancel = cancel
+	m.setCancel(cancel)
 
 	// Check for interrupts
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
 	done := make(chan bool, 1)
 	go func() {
 		select {
 		case <-sigs:
-			fmt.Fprintln(m.wout, "Received signal, shutting down...")
+			m.logf(m.wout, EventShuttingDown, "", "Received signal, shutting down...\n")
 			cancel()
 		case <-ctx.Done():
 		}
 		done <- true
 	}()
 
+	// Get the order to start (and stop) the commands in
+	levels, err := m.conf.procLevels()
+	if err != nil {
+		cancel()
+		<-done
+		return m.setRunErr(err)
+	}
+
+	// Get the secrets the commands use
+	secrets, err := m.conf.resolveSecrets()
+	if err != nil {
+		cancel()
+		<-done
+		return m.setRunErr(err)
+	}
+
 	// Create the commands
-	m.cmds = m.createCmds()
+	cmds := m.createCmds(secrets)
 
-	// Run the commands
-	var wg sync.WaitGroup
-	for _, cmd := range m.cmds {
-		wg.Add(1)
-		go func(cmd *Command) {
-			defer wg.Done()
-			cmd.Run(ctx)
-		}(cmd)
+	// The commands get their own context so that, on shutdown,
+	// they can be stopped in dependency order rather than all
+	// at once
+	procCtx, procCancel := context.WithCancel(context.Background())
+	defer procCancel()
+
+	m.lock.Lock()
+	m.cmds = cmds
+	m.ctx = ctx
+	m.procCtx = procCtx
+	socket := m.socket
+	m.lock.Unlock()
+
+	// Listen for control requests
+	if socket != "" {
+		ln, err := listenCtl(socket)
+		if err != nil {
+			m.logf(m.werr, EventLog, "", "Control socket disabled: %s\n", err)
+		} else {
+			defer os.Remove(socket)
+			defer ln.Close()
+			go m.serveCtl(ln)
+		}
 	}
 
-	// Wait for the commands to finish
-	wg.Wait()
+	// Watch for file changes
+	for _, cmd := range cmds {
+		if cmd.conf.Watch == nil {
+			continue
+		}
+		w, err := newWatcher(m, cmd)
+		if err != nil {
+			cmd.werr.Event(EventLog, "Not watching for changes: %s\n", err)
+			continue
+		}
+		m.SetKeepAlive(true)
+		go w.run(ctx)
+	}
+
+	// Run the commands (once their dependencies are ready)
+	for _, cmd := range cmds {
+		m.launch(cmd, true)
+	}
+	m.notifyExit()
+
+	// Wait for the commands to finish (or for a shutdown)
+wait:
+	for {
+		select {
+		case <-m.exits:
+			if m.isIdle() {
+				break wait
+			}
+
+		case <-ctx.Done():
+			m.lock.Lock()
+			m.closing = true
+			m.lock.Unlock()
+
+			m.stopInOrder(levels)
+			for m.activeCount() > 0 {
+				<-m.exits
+			}
+			break wait
+		}
+	}
 
 	// Wait for the context to finish
 	cancel()
@@ -116,6 +341,219 @@ func (m *Manager) Run(ctx context.Context) error {
 	return m.Error()
 }
 
+// launch runs the command in the background, optionally waiting
+// for its dependencies first. It returns an error if the command
+// is already running or if the manager is shutting down.
+func (m *Manager) launch(cmd *Command, waitDeps bool) error {
+	m.lock.Lock()
+	if m.closing || m.procCtx == nil {
+		m.lock.Unlock()
+		return fmt.Errorf("not running")
+	}
+	if !cmd.claim() {
+		m.lock.Unlock()
+		return fmt.Errorf("process %q is already running", cmd.Name())
+	}
+	m.active++
+	ctx, procCtx := m.ctx, m.procCtx
+	m.lock.Unlock()
+
+	go func() {
+		defer m.notifyExit()
+		defer cmd.release()
+		defer func() {
+			m.lock.Lock()
+			m.active--
+			m.lock.Unlock()
+		}()
+
+		if waitDeps {
+			if err := m.waitForDeps(ctx, cmd); err != nil {
+				if ctx.Err() != nil {
+					err = nil
+				} else {
+					cmd.wout.Event(EventNotStarting, "Not starting: %s\n", err)
+				}
+				cmd.skip(err)
+				return
+			}
+		}
+		cmd.Run(procCtx)
+		m.handleExit(cmd)
+	}()
+	return nil
+}
+
+// notifyExit lets Run know that a command has exited.
+func (m *Manager) notifyExit() {
+	select {
+	case m.exits <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) activeCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.active
+}
+
+// isIdle returns true if no commands are running (or about to
+// be restarted) and the manager isn't being kept alive.
+func (m *Manager) isIdle() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.active == 0 && m.pending == 0 && !m.keepAlive
+}
+
+// Commands returns the manager's commands. It's empty until
+// Run has been called.
+func (m *Manager) Commands() []*Command {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	cmds := make([]*Command, len(m.cmds))
+	copy(cmds, m.cmds)
+	return cmds
+}
+
+// Start starts a command that isn't running (e.g. one that was
+// stopped or has finished). Its dependencies aren't waited for.
+func (m *Manager) Start(name string) error {
+	cmd := m.getCmd(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown process %q", name)
+	}
+	return m.launch(cmd, false)
+}
+
+// Stop stops a running command and waits for it to exit. The command
+// won't be restarted (regardless of its restart policy) until it's
+// started again. Once a command has been stopped this way, Run keeps
+// running (so that it can be started again) until the manager is
+// shut down.
+func (m *Manager) Stop(name string) error {
+	cmd := m.getCmd(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown process %q", name)
+	}
+	if !cmd.isActive() {
+		return fmt.Errorf("process %q isn't running", name)
+	}
+	m.SetKeepAlive(true)
+	cmd.Cancel()
+	cmd.waitIdle()
+	return nil
+}
+
+// Restart stops a command (if it's running) and starts it again.
+func (m *Manager) Restart(name string) error {
+	cmd := m.getCmd(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown process %q", name)
+	}
+
+	// Count the command as running while it's stopped, so that Run
+	// doesn't return in between
+	m.lock.Lock()
+	m.pending++
+	m.lock.Unlock()
+	defer func() {
+		m.lock.Lock()
+		m.pending--
+		m.lock.Unlock()
+		m.notifyExit()
+	}()
+
+	if cmd.isActive() {
+		cmd.Cancel()
+		cmd.waitIdle()
+	}
+	return m.launch(cmd, false)
+}
+
+// handleExit applies the manager's exit policies once a command has
+// exited, stopping all of the other commands if needed.
+func (m *Manager) handleExit(cmd *Command) {
+	// Was it stopped on purpose?
+	if cmd.isStopped() {
+		return
+	}
+
+	// Should everything be stopped?
+	var reason string
+	switch {
+	case cmd.conf.ExitOnExit:
+		reason = "exited"
+	case cmd.Status() == CmdFailed && m.conf.OnFailure == OnFailureStopAll:
+		reason = "failed"
+	default:
+		return
+	}
+
+	// Only the first command to exit is used for the exit code
+	m.lock.Lock()
+	first := m.exitCmd == nil
+	if first {
+		m.exitCmd = cmd
+	}
+	m.lock.Unlock()
+	if !first {
+		return
+	}
+
+	m.logf(m.wout, EventShuttingDown, cmd.Name(), "%s %s, shutting down...\n", cmd.Name(), reason)
+	m.Cancel()
+}
+
+// logf writes one of fun-run's own messages, in the config's
+// log format.
+func (m *Manager) logf(w io.Writer, typ EventType, proc string, format string, a ...any) {
+	m.lock.RLock()
+	hidden := m.hidden
+	m.lock.RUnlock()
+
+	msg := redact(fmt.Sprintf(format, a...), hidden)
+	if m.conf.logConf().Format == LogFormatJSON {
+		writeEntry(w, LogEntry{
+			Time:    time.Now(),
+			Proc:    proc,
+			Event:   typ,
+			Message: msg,
+		})
+		return
+	}
+	io.WriteString(w, msg)
+}
+
+// ExitCode returns the exit code fun-run should exit with once
+// Run has returned. If a command caused the manager to stop (e.g.
+// because it has exit_on_exit set), its exit code is used. Otherwise
+// it's 1 if Run failed (or any command has an error).
+func (m *Manager) ExitCode() int {
+	m.lock.RLock()
+	cmd := m.exitCmd
+	m.lock.RUnlock()
+
+	if cmd != nil {
+		code, sig := cmd.ExitStatus()
+		if s, ok := sig.(syscall.Signal); ok {
+			return 128 + int(s)
+		}
+		if code >= 0 {
+			return code
+		}
+		if cmd.Error() != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if m.Error() != nil {
+		return 1
+	}
+	return 0
+}
+
 func (m *Manager) setCancel(c context.CancelFunc) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -130,10 +568,24 @@ func (m *Manager) Cancel() {
 	}
 }
 
+// setRunErr records the error that stopped Run before the commands
+// were started, so that Error and ExitCode report it. It returns the
+// error.
+func (m *Manager) setRunErr(err error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.runErr = err
+	return err
+}
+
 func (m *Manager) Error() error {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
+	if m.runErr != nil {
+		return m.runErr
+	}
+
 	me := MapError{}
 	for _, cmd := range m.cmds {
 		if err := cmd.Error(); err != nil {
@@ -0,0 +1,227 @@
+//go:build !windows
+
+package funrun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startManager runs a manager in the background, returning a
+// channel that gets Run's error.
+func startManager(t *testing.T, conf *Conf) (*Manager, <-chan error) {
+	t.Helper()
+	conf.Socket = ""
+	m := NewManager(conf)
+	m.SetOutputs(io.Discard, io.Discard)
+	errs := make(chan error, 1)
+	go func() { errs <- m.Run(context.Background()) }()
+	t.Cleanup(m.Shutdown)
+	return m, errs
+}
+
+// waitFor waits for a condition to be true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownAfterRecoveredFailure(t *testing.T) {
+	// The first run fails, and the restart keeps running
+	conf := mustReadConf(t, `
+procs:
+  - name: a
+    cmd: sh
+    args: ["-c", "if [ -f ran ]; then exec sleep 30; else touch ran; exit 3; fi"]
+    restart: on-fail
+    restart_delay: 10ms
+`)
+	m, errs := startManager(t, conf)
+
+	waitFor(t, "the restart", func() bool {
+		cmds := m.Commands()
+		return len(cmds) == 1 && cmds[0].Restarts() == 1 && cmds[0].PID() != 0
+	})
+	m.Shutdown()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Run returned %v, want nil", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the manager to shut down")
+	}
+	if code := m.ExitCode(); code != 0 {
+		t.Errorf("ExitCode() = %d, want 0", code)
+	}
+}
+
+func TestLogfRedactsSecrets(t *testing.T) {
+	conf := mustReadConf(t, `
+secrets:
+  TOKEN: {command: "echo s3cr3t-token"}
+procs:
+  - name: a
+    cmd: echo
+    secrets: [TOKEN]
+`)
+	m := NewManager(conf)
+	m.createCmds(map[string]string{"TOKEN": "s3cr3t-token"})
+
+	var buf bytes.Buffer
+	m.logf(&buf, EventLog, "", "Control socket disabled: listen unix /tmp/%s.sock: bind: address in use\n", "s3cr3t-token")
+	if got := buf.String(); strings.Contains(got, "s3cr3t-token") || !strings.Contains(got, "/tmp/********.sock") {
+		t.Errorf("logf wrote %q, want the secret redacted", got)
+	}
+}
+
+// waitForProc waits for a command's process to start.
+func waitForProc(t *testing.T, m *Manager, name string) *Command {
+	t.Helper()
+	var cmd *Command
+	waitFor(t, name+" to start", func() bool {
+		cmd = m.getCmd(name)
+		return cmd != nil && cmd.PID() != 0
+	})
+	return cmd
+}
+
+// checkRunning checks that Run hasn't returned.
+func checkRunning(t *testing.T, errs <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errs:
+		t.Fatalf("Run returned (%v), want it to still be running", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRestartOnlyProc(t *testing.T) {
+	conf := mustReadConf(t, `
+procs:
+  - name: s
+    cmd: sleep
+    args: ["30"]
+`)
+	m, errs := startManager(t, conf)
+	cmd := waitForProc(t, m, "s")
+	pid := cmd.PID()
+
+	if err := m.Restart("s"); err != nil {
+		t.Fatal(err)
+	}
+	checkRunning(t, errs)
+	if got := cmd.PID(); got == 0 || got == pid {
+		t.Errorf("PID after the restart = %d, want a new process (was %d)", got, pid)
+	}
+	if !cmd.isActive() {
+		t.Error("the process isn't running after the restart")
+	}
+}
+
+func TestStopAndStart(t *testing.T) {
+	conf := mustReadConf(t, `
+procs:
+  - name: s
+    cmd: sleep
+    args: ["30"]
+`)
+	m, errs := startManager(t, conf)
+	cmd := waitForProc(t, m, "s")
+
+	// Stopping the only process keeps the manager running...
+	if err := m.Stop("s"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.PID() != 0 || cmd.isActive() {
+		t.Error("the process is still running after Stop")
+	}
+	checkRunning(t, errs)
+	if err := m.Stop("s"); err == nil {
+		t.Error("stopping a stopped process should fail")
+	}
+
+	// ...so that it can be started again
+	if err := m.Start("s"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the process to start again", func() bool { return cmd.PID() != 0 })
+	if err := m.Start("s"); err == nil {
+		t.Error("starting a running process should fail")
+	}
+	for _, f := range []func(string) error{m.Start, m.Stop, m.Restart} {
+		if err := f("ghost"); err == nil || !strings.Contains(err.Error(), `unknown process "ghost"`) {
+			t.Errorf("error = %v, want an unknown process error", err)
+		}
+	}
+}
+
+func TestSignal(t *testing.T) {
+	conf := mustReadConf(t, `
+procs:
+  - name: s
+    cmd: sleep
+    args: ["30"]
+`)
+	m, errs := startManager(t, conf)
+	cmd := waitForProc(t, m, "s")
+
+	if err := m.Signal("ghost", syscall.SIGUSR1); err == nil {
+		t.Error("signalling an unknown process should fail")
+	}
+	if err := m.Signal("s", syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process is killed by the signal, and Run returns since
+	// there's nothing left to run
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+	if _, sig := cmd.ExitStatus(); sig != syscall.SIGUSR1 {
+		t.Errorf("exit signal = %v, want %v", sig, syscall.SIGUSR1)
+	}
+	if err := m.Signal("s", syscall.SIGUSR1); err == nil {
+		t.Error("signalling a process that isn't running should fail")
+	}
+}
+
+func TestExitCodeWhenRunFails(t *testing.T) {
+	// Run fails before any of the commands are started
+	conf := mustReadConf(t, `
+secrets:
+  DB: {command: "false"}
+procs:
+  - name: a
+    cmd: echo
+    secrets: [DB]
+`)
+	m, errs := startManager(t, conf)
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), `failed to get secret "DB"`) {
+			t.Errorf("Run returned %v, want the secret's error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+	if err := m.Error(); err == nil {
+		t.Error("Error() = nil, want the secret's error")
+	}
+	if code := m.ExitCode(); code != 1 {
+		t.Errorf("ExitCode() = %d, want 1", code)
+	}
+}
@@ -0,0 +1,386 @@
+package funrun
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stdinName is the name used for a config read from stdin.
+const stdinName = "<stdin>"
+
+// confSource is a config's yaml after the config files (and the
+// files they include) have been merged, along with the file that
+// each part of it came from.
+type confSource struct {
+	root     *yaml.Node            // The merged config (a mapping node)
+	files    map[*yaml.Node]string // The file each node came from
+	problems []Problem             // Problems found while parsing the files
+	stdin    Format                // Format of a config read from stdin
+	dir      string                // Directory of the first config file
+}
+
+// loadConfSource reads the config files, and the files they include,
+// and merges them in order. A config read from stdin is parsed in
+// the given format (or as YAML, if it's empty).
+func loadConfSource(paths []string, stdinFormat Format) (*confSource, error) {
+	src := &confSource{
+		root:  &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		files: make(map[*yaml.Node]string),
+		stdin: stdinFormat,
+	}
+	if len(paths) > 0 && paths[0] != "-" {
+		if d := filepath.Dir(paths[0]); d != "." {
+			src.dir = d
+		}
+	}
+	for _, p := range paths {
+		n, err := src.load(p, nil)
+		if err != nil {
+			return nil, err
+		}
+		src.mergeConf(src.root, n)
+	}
+	if err := src.resolveExtends(); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// readConfFile reads a config file ("-" for stdin).
+func readConfFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// load reads a config file, merging it on top of the files it
+// includes. The stack holds the files that are currently being
+// loaded, to catch include cycles.
+func (s *confSource) load(path string, stack []string) (*yaml.Node, error) {
+	name := path
+	if path == "-" {
+		name = stdinName
+	}
+	for _, p := range stack {
+		if p == name {
+			return nil, fmt.Errorf("include cycle detected: %s -> %s", strings.Join(stack, " -> "), name)
+		}
+	}
+	stack = append(stack, name)
+
+	// Read the file...
+	b, err := readConfFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", name, err)
+	}
+
+	// ...parse it (either as a Procfile, with the .env file next to
+	// it, or as a fun-run config)...
+	n := &yaml.Node{}
+	if IsProcfile(path) {
+		envs, err := ReadDotEnv(filepath.Join(filepath.Dir(path), ".env"))
+		if err != nil {
+			return nil, err
+		}
+		pc, err := ProcfileConf(b, envs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Procfile %s: %w", name, err)
+		}
+		if err := n.Encode(pc); err != nil {
+			return nil, fmt.Errorf("failed to parse Procfile %s: %w", name, err)
+		}
+	} else if n, err = parseNode(name, b, s.format(path)); err != nil {
+		return nil, err
+	}
+
+	// ...check that it's a config on its own...
+	root := n
+	if root.Kind == yaml.DocumentNode {
+		root = root.Content[0]
+	}
+	if root.Kind == 0 {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	s.track(root, name)
+	if err := root.Decode(&Conf{}); err != nil {
+		s.problems = append(s.problems, decodeProblems(name, root, err)...)
+	}
+
+	// ...and merge it on top of the files it includes
+	incs := removeKey(root, "include")
+	if incs == nil {
+		return root, nil
+	}
+	var paths []string
+	if err := incs.Decode(&paths); err != nil {
+		return nil, fmt.Errorf("%s: invalid include list: %w", s.pos(incs), err)
+	}
+	base := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, p := range paths {
+		if !filepath.IsAbs(p) && path != "-" {
+			p = filepath.Join(filepath.Dir(path), p)
+		}
+		inc, err := s.load(p, stack)
+		if err != nil {
+			return nil, err
+		}
+		s.mergeConf(base, inc)
+	}
+	s.mergeConf(base, root)
+	return base, nil
+}
+
+// format returns the format of a config file.
+func (s *confSource) format(path string) Format {
+	if path == "-" {
+		if s.stdin == "" {
+			return FormatYAML
+		}
+		return s.stdin
+	}
+	return FormatOf(path)
+}
+
+// track records the file that a node (and everything in it) came from.
+func (s *confSource) track(n *yaml.Node, file string) {
+	s.files[n] = file
+	for _, c := range n.Content {
+		s.track(c, file)
+	}
+}
+
+// pos returns the file, line and column a node came from.
+func (s *confSource) pos(n *yaml.Node) string {
+	f, ok := s.files[n]
+	if !ok {
+		return "config"
+	}
+	if n.Line == 0 {
+		return f
+	}
+	return fmt.Sprintf("%s:%d:%d", f, n.Line, n.Column)
+}
+
+// decodeProblems converts the error from decoding the merged config
+// into problems, in the files the values came from.
+func (s *confSource) decodeProblems(err error) []Problem {
+	ps := decodeProblems("", s.root, err)
+	for i := range ps {
+		ps[i].File = "config"
+		if f, ok := s.files[nodeAt(s.root, ps[i].Line)]; ok {
+			ps[i].File = f
+		}
+	}
+	return ps
+}
+
+// procNode returns the node for the i-th process.
+func (s *confSource) procNode(i int) *yaml.Node {
+	procs := getKey(s.root, "procs")
+	if procs == nil || procs.Kind != yaml.SequenceNode || i >= len(procs.Content) {
+		return nil
+	}
+	return procs.Content[i]
+}
+
+// mergeConf merges a config on top of another. The processes are
+// merged by name, and everything else is deep-merged.
+func (s *confSource) mergeConf(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		if cur := getKey(dst, k.Value); k.Value == "procs" && cur != nil &&
+			cur.Kind == yaml.SequenceNode && v.Kind == yaml.SequenceNode {
+			s.mergeProcs(cur, v)
+			continue
+		}
+		s.merge(dst, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{k, v}})
+	}
+}
+
+// merge deep-merges src on top of dst. Maps are merged key
+// by key, and anything else is replaced.
+func (s *confSource) merge(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		cur := getKey(dst, k.Value)
+		switch {
+		case cur == nil:
+			dst.Content = append(dst.Content, k, v)
+		case cur.Kind == yaml.MappingNode && v.Kind == yaml.MappingNode:
+			s.merge(cur, v)
+		default:
+			setKey(dst, k.Value, v)
+		}
+	}
+}
+
+// mergeProcs merges a list of processes on top of another. Processes
+// with the same name are merged, and the rest are added to the end.
+func (s *confSource) mergeProcs(dst, src *yaml.Node) {
+	// Only merge with the processes that were already there, so
+	// that duplicates within a file are still reported
+	existing := dst.Content[:len(dst.Content):len(dst.Content)]
+	for _, p := range src.Content {
+		var cur *yaml.Node
+		if name := getKey(p, "name"); name != nil && name.Value != "" {
+			for _, d := range existing {
+				if n := getKey(d, "name"); n != nil && n.Value == name.Value {
+					cur = d
+					break
+				}
+			}
+		}
+		if cur != nil && cur.Kind == yaml.MappingNode && p.Kind == yaml.MappingNode {
+			s.mergeProc(cur, p)
+		} else {
+			dst.Content = append(dst.Content, p)
+		}
+	}
+}
+
+// mergeProc merges a process on top of another. Setting one of
+// "cmd" or "cmds" replaces the other.
+func (s *confSource) mergeProc(dst, src *yaml.Node) {
+	if getKey(src, "cmd") != nil {
+		removeKey(dst, "cmds")
+	}
+	if getKey(src, "cmds") != nil {
+		removeKey(dst, "cmd")
+	}
+	s.merge(dst, src)
+}
+
+// resolveExtends merges each process that extends another on top of
+// (a copy of) the process it extends.
+func (s *confSource) resolveExtends() error {
+	procs := getKey(s.root, "procs")
+	if procs == nil || procs.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	byName := make(map[string]*yaml.Node)
+	for _, p := range procs.Content {
+		if n := getKey(p, "name"); n != nil && n.Value != "" {
+			byName[n.Value] = p
+		}
+	}
+
+	resolved := make(map[*yaml.Node]bool)
+	var resolve func(p *yaml.Node, stack []string) error
+	resolve = func(p *yaml.Node, stack []string) error {
+		if resolved[p] {
+			return nil
+		}
+		ext := getKey(p, "extends")
+		if ext == nil {
+			resolved[p] = true
+			return nil
+		}
+
+		// Find the process it extends...
+		var name string
+		if n := getKey(p, "name"); n != nil {
+			name = n.Value
+		}
+		for _, st := range stack {
+			if st == ext.Value {
+				return fmt.Errorf("%s: extends cycle detected: %s -> %s", s.pos(ext), strings.Join(stack, " -> "), ext.Value)
+			}
+		}
+		base, ok := byName[ext.Value]
+		if !ok {
+			return fmt.Errorf("%s: process %q extends unknown process %q", s.pos(ext), name, ext.Value)
+		}
+		if err := resolve(base, append(stack, ext.Value)); err != nil {
+			return err
+		}
+
+		// ...and merge this one on top of a copy of it
+		own := *p
+		own.Content = append([]*yaml.Node{}, p.Content...)
+		removeKey(&own, "extends")
+		p.Content = nil
+		for i := 0; i+1 < len(base.Content); i += 2 {
+			if k := base.Content[i].Value; k != "name" {
+				p.Content = append(p.Content, s.copyNode(base.Content[i]), s.copyNode(base.Content[i+1]))
+			}
+		}
+		s.mergeProc(p, &own)
+		resolved[p] = true
+		return nil
+	}
+
+	for _, p := range procs.Content {
+		var name string
+		if n := getKey(p, "name"); n != nil {
+			name = n.Value
+		}
+		if err := resolve(p, []string{name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyNode deep-copies a node, keeping track of where it came from.
+func (s *confSource) copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, cn := range n.Content {
+		c.Content[i] = s.copyNode(cn)
+	}
+	if f, ok := s.files[n]; ok {
+		s.files[&c] = f
+	}
+	return &c
+}
+
+// getKey returns the value of a key in a mapping node (or nil).
+func getKey(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setKey replaces the value of a key in a mapping node.
+func setKey(n *yaml.Node, key string, v *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1] = v
+			return
+		}
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+}
+
+// removeKey removes a key from a mapping node, returning its value.
+func removeKey(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			v := n.Content[i+1]
+			n.Content = append(n.Content[:i:i], n.Content[i+2:]...)
+			return v
+		}
+	}
+	return nil
+}
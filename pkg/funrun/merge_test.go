@@ -0,0 +1,260 @@
+package funrun
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncludeCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // Config files, by path (fun-run.yaml is read)
+		err   string            // Part of the error
+	}{
+		{
+			name:  "self",
+			files: map[string]string{"fun-run.yaml": "include: [fun-run.yaml]"},
+			err:   "include cycle detected",
+		},
+		{
+			name: "two files",
+			files: map[string]string{
+				"fun-run.yaml": "include: [a.yaml]",
+				"a.yaml":       "include: [b.yaml]",
+				"b.yaml":       "include: [a.yaml]",
+			},
+			err: "a.yaml -> ",
+		},
+		{
+			name: "through a subdirectory",
+			files: map[string]string{
+				"fun-run.yaml": "include: [sub/a.yaml]",
+				"sub/a.yaml":   "include: [../fun-run.yaml]",
+			},
+			err: "include cycle detected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			_, err := ReadConf(filepath.Join(dir, "fun-run.yaml"))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadConf() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIncludeDiamond(t *testing.T) {
+	// Including the same file twice (but not recursively) is fine
+	dir := t.TempDir()
+	writeFile(t, dir, "common.yaml", "procs: [{name: db, cmd: sleep}]")
+	writeFile(t, dir, "a.yaml", "include: [common.yaml]")
+	writeFile(t, dir, "b.yaml", "include: [common.yaml]")
+	path := writeFile(t, dir, "fun-run.yaml", "include: [a.yaml, b.yaml]\nprocs: [{name: web, cmd: cat}]")
+	conf, err := ReadConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := procNames(conf); got != "db web" {
+		t.Errorf("processes = %q, want %q", got, "db web")
+	}
+}
+
+func TestMergeProcsByName(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "base.yaml", `
+on_failure: stop-all
+envs: {A: "1", B: "1"}
+procs:
+  - name: db
+    cmd: sleep
+    args: [-D, data]
+    envs: {PGPORT: "5432", PGUSER: app}
+  - name: web
+    cmd: cat
+`)
+	path := writeFile(t, dir, "fun-run.yaml", `
+include: [base.yaml]
+envs: {B: "2"}
+procs:
+  - name: db
+    args: [-D, other]
+    envs: {PGPORT: "5433"}
+  - name: worker
+    cmd: "true"
+`)
+	conf, err := ReadConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Processes with the same name are merged in place, and new ones
+	// are added to the end...
+	if got := procNames(conf); got != "db web worker" {
+		t.Fatalf("processes = %q, want %q", got, "db web worker")
+	}
+	db := conf.Procs[0]
+	if db.Cmd != "sleep" {
+		t.Errorf("db cmd = %q, want %q", db.Cmd, "sleep")
+	}
+
+	// ...lists are replaced, and maps are merged key by key...
+	if want := []string{"-D", "other"}; !reflect.DeepEqual(db.Args, want) {
+		t.Errorf("db args = %q, want %q", db.Args, want)
+	}
+	if want := map[string]string{"A": "1", "B": "2", "PGPORT": "5433", "PGUSER": "app"}; !reflect.DeepEqual(db.Envs, want) {
+		t.Errorf("db envs = %v, want %v", db.Envs, want)
+	}
+
+	// ...and so is the rest of the config
+	if conf.OnFailure != OnFailureStopAll {
+		t.Errorf("on_failure = %q, want %q", conf.OnFailure, OnFailureStopAll)
+	}
+	if want := map[string]string{"A": "1", "B": "2"}; !reflect.DeepEqual(conf.Envs, want) {
+		t.Errorf("envs = %v, want %v", conf.Envs, want)
+	}
+}
+
+func TestMergeKeepsDuplicatesInAFile(t *testing.T) {
+	// Only processes from earlier files are merged, so a duplicate
+	// name within one file is still reported
+	dir := t.TempDir()
+	path := writeFile(t, dir, "fun-run.yaml", "procs: [{name: a, cmd: x}, {name: a, cmd: y}]")
+	if _, err := ReadConf(path); err == nil || !strings.Contains(err.Error(), `duplicate process name "a"`) {
+		t.Errorf("ReadConf() error = %v, want a duplicate name error", err)
+	}
+}
+
+func TestMergeCmdReplacesCmds(t *testing.T) {
+	tests := []struct {
+		name string
+		base string // The base process's keys
+		over string // The overriding process's keys
+		cmd  string
+		cmds []string
+	}{
+		{
+			name: "cmd replaces cmds",
+			base: "cmds: [a, b]",
+			over: "cmd: cat",
+			cmd:  "cat",
+		},
+		{
+			name: "cmds replaces cmd",
+			base: "cmd: echo",
+			over: "cmds: [b, c]",
+			cmds: []string{"b", "c"},
+		},
+		{
+			name: "cmds replaces cmds",
+			base: "cmds: [a, b]",
+			over: "cmds: [c]",
+			cmds: []string{"c"},
+		},
+		{
+			name: "neither keeps cmd",
+			base: "cmd: echo",
+			over: "restart: always",
+			cmd:  "echo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(what string, p *ProcConf) {
+				if p.Cmd != tt.cmd || !reflect.DeepEqual(p.Cmds, tt.cmds) {
+					t.Errorf("%s: got cmd %q, cmds %q; want cmd %q, cmds %q", what, p.Cmd, p.Cmds, tt.cmd, tt.cmds)
+				}
+			}
+
+			// Merging across files...
+			dir := t.TempDir()
+			writeFile(t, dir, "base.yaml", "procs:\n  - name: p\n"+indent(tt.base, "    "))
+			path := writeFile(t, dir, "fun-run.yaml", "include: [base.yaml]\nprocs:\n  - name: p\n"+indent(tt.over, "    "))
+			conf, err := ReadConf(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check("include", conf.Procs[0])
+
+			// ...and with extends
+			conf = mustReadConf(t, "procs:\n  - name: base\n"+indent(tt.base, "    ")+
+				"  - name: p\n    extends: base\n"+indent(tt.over, "    "))
+			check("extends", conf.Procs[1])
+		})
+	}
+}
+
+func TestExtends(t *testing.T) {
+	conf := mustReadConf(t, `
+procs:
+  - name: c
+    extends: b
+    envs: {C: "3"}
+  - name: b
+    extends: a
+    envs: {B: "2"}
+  - name: a
+    cmd: echo
+    args: [--fast]
+    envs: {A: "1"}
+`)
+	c := conf.Procs[0]
+	if c.Name != "c" || c.Cmd != "echo" || !reflect.DeepEqual(c.Args, []string{"--fast"}) {
+		t.Errorf("c = %q %q %q, want c, echo and [--fast]", c.Name, c.Cmd, c.Args)
+	}
+	if want := map[string]string{"A": "1", "B": "2", "C": "3"}; !reflect.DeepEqual(c.Envs, want) {
+		t.Errorf("c envs = %v, want %v", c.Envs, want)
+	}
+
+	// The process that's extended isn't changed
+	if a := conf.Procs[2]; !reflect.DeepEqual(a.Envs, map[string]string{"A": "1"}) {
+		t.Errorf("a envs = %v, want only A", a.Envs)
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{
+			name: "self",
+			conf: "procs: [{name: a, cmd: x, extends: a}]",
+			err:  "extends cycle detected: a -> a",
+		},
+		{
+			name: "cycle",
+			conf: "procs: [{name: a, extends: b}, {name: b, extends: c}, {name: c, extends: a}]",
+			err:  "extends cycle detected: a -> b -> c -> a",
+		},
+		{
+			name: "unknown",
+			conf: "procs: [{name: a, extends: ghost}]",
+			err:  `process "a" extends unknown process "ghost"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readTestConf(t, tt.conf)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadConf() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// procNames returns the names of a config's processes.
+func procNames(conf *Conf) string {
+	var names []string
+	for _, p := range conf.Procs {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, " ")
+}
@@ -1,10 +1,12 @@
 package funrun
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/muesli/termenv"
 )
@@ -30,32 +32,70 @@ func pickAColor(i int) termenv.ANSIColor {
 	}
 }
 
-func fmtPrefix(n, t string, w, c int) string {
-	// Format the (uncolored) prefix
-	s := fmt.Sprintf("%s%s (%s) | ", n, strings.Repeat(" ", w-len(n)), t)
+// timestampFormat is the format of the times shown
+// before each line (with log.timestamps set).
+const timestampFormat = "15:04:05.000"
 
-	// Create the color-er
-	f := termenv.String().Foreground(pickAColor(c))
-
-	// Return the color-ified prefix
-	return f.Styled(s)
-}
+// Limits for the partial line held by a PrefixWriter.
+const (
+	flushDelay  = 100 * time.Millisecond // Time to wait for the rest of a partial line before writing it
+	maxLineSize = 64 * 1024              // Length at which a partial line is written as it is
+)
 
+// PrefixWriter writes a process's output with its name before each
+// line. Output is buffered until the end of each line (so that lines
+// from different processes don't get mixed together), and a partial
+// line is written once no more output arrives for it (or when the
+// writer is flushed). Lines that are overwritten with "\r" (e.g.
+// progress bars) are written as their final text.
 type PrefixWriter struct {
-	Name   string
-	Color  termenv.ANSIColor
-	Writer io.Writer
+	Name     string
+	Stream   string // "stdout" or "stderr"
+	Color    termenv.ANSIColor
+	Writer   io.Writer
+	taps     []io.Writer
+	redact   []string    // Secret values to hide from the output
+	width    int         // Width to pad the name to (if log.align is set)
+	log      LogConf     // How to write the output
+	pid      int         // ID of the running process
+	restarts int         // Number of times the process has been restarted
+	buf      []byte      // Partial line waiting to be written
+	timer    *time.Timer // Writes the partial line after flushDelay
 	sync.Mutex
 }
 
 func NewPrefixWriter(name, outType string, nameWidth, color int, write io.Writer) *PrefixWriter {
 	return &PrefixWriter{
 		Name:   name,
+		Stream: outType,
 		Color:  pickAColor(color),
 		Writer: write,
+		width:  nameWidth,
+		log:    LogConf{Format: LogFormatText},
 	}
 }
 
+// SetLogConf sets how the output is written.
+func (w *PrefixWriter) SetLogConf(l LogConf) {
+	w.Lock()
+	defer w.Unlock()
+	w.log = l
+}
+
+// setPID sets the ID of the running process (or 0 once it exits).
+func (w *PrefixWriter) setPID(pid int) {
+	w.Lock()
+	defer w.Unlock()
+	w.pid = pid
+}
+
+// setRestarts sets the restart count included in JSON output.
+func (w *PrefixWriter) setRestarts(n int) {
+	w.Lock()
+	defer w.Unlock()
+	w.restarts = n
+}
+
 func (w *PrefixWriter) withColor(s string) string {
 	return termenv.
 		String().
@@ -63,26 +103,169 @@ func (w *PrefixWriter) withColor(s string) string {
 		Styled(s)
 }
 
+// prefix returns the (uncolored) text before each line: the time, the
+// process's name, its stream and its ID (depending on the log settings).
+// Lines of output end with a separator, which is "|" for stdout and "!"
+// for stderr.
+func (w *PrefixWriter) prefix(output bool) string {
+	var b strings.Builder
+	if w.log.Timestamps {
+		b.WriteString(time.Now().Format(timestampFormat) + " ")
+	}
+	b.WriteString(w.Name)
+	if w.log.Align && len(w.Name) < w.width {
+		b.WriteString(strings.Repeat(" ", w.width-len(w.Name)))
+	}
+	if !output {
+		return b.String() + " "
+	}
+	if w.log.Stream {
+		b.WriteString(" (" + w.Stream + ")")
+	}
+	if w.log.PID && w.pid != 0 {
+		fmt.Fprintf(&b, " [%d]", w.pid)
+	}
+	if w.Stream == "stderr" {
+		return b.String() + " ! "
+	}
+	return b.String() + " | "
+}
+
 func (w *PrefixWriter) Write(p []byte) (int, error) {
 	w.Lock()
 	defer w.Unlock()
 
-	// Write the prefix
-	pfx := w.withColor(w.Name + " | ")
-	b := []byte(pfx)
-	n, err := w.Writer.Write(b)
-	if err != nil {
-		return n, err
+	// Copy the (unprefixed) data to any taps
+	for _, t := range w.taps {
+		t.Write([]byte(redact(string(p), w.redact)))
 	}
 
-	// Write the data
-	n, err = w.Writer.Write(p)
-	if err != nil {
-		return n, err
+	// Write each complete line...
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
 	}
 
-	// Return the number of bytes written
-	return n, nil
+	// ...and hold on to the rest until no more output arrives for
+	// it. Only the last version of an overwritten line is kept, and
+	// a long line is written as it is (a trailing "\r" could be the
+	// start of a "\r\n")
+	if n := len(w.buf); n > 1 {
+		if i := bytes.LastIndexByte(w.buf[:n-1], '\r'); i >= 0 {
+			w.buf = w.buf[i+1:]
+		}
+	}
+	if len(w.buf) >= maxLineSize {
+		err := w.writeLine(string(w.buf))
+		w.buf = w.buf[:0]
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil
+	} else if w.timer == nil {
+		w.timer = time.AfterFunc(flushDelay, func() { w.Flush() })
+	} else {
+		w.timer.Reset(flushDelay)
+	}
+
+	// Return the number of bytes written (before any were redacted)
+	return len(p), nil
+}
+
+// Flush writes any partial line that's waiting for the rest of
+// its output (e.g. once the process has exited).
+func (w *PrefixWriter) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.flush()
+}
+
+// flush writes the partial line. The caller must hold the lock.
+func (w *PrefixWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+// writeLine writes a line of output (without its newline), with the
+// prefix or as JSON. Of the parts of a line that's overwritten with
+// "\r", only the last one (that isn't empty) is written.
+func (w *PrefixWriter) writeLine(line string) error {
+	if strings.Contains(line, "\r") {
+		parts := strings.Split(line, "\r")
+		line = ""
+		for i := len(parts) - 1; i >= 0; i-- {
+			if parts[i] != "" {
+				line = parts[i]
+				break
+			}
+		}
+	}
+
+	// Hide any secrets...
+	line = redact(line, w.redact)
+
+	// Write the line as JSON...
+	if w.log.Format == LogFormatJSON {
+		return writeEntry(w.Writer, LogEntry{
+			Time:     time.Now(),
+			Proc:     w.Name,
+			Stream:   w.Stream,
+			PID:      w.pid,
+			Restarts: w.restarts,
+			Message:  line,
+		})
+	}
+
+	// ...or with the prefix (in one write, so that it
+	// isn't split up by other processes' output)
+	_, err := io.WriteString(w.Writer, w.withColor(w.prefix(true))+line+"\n")
+	return err
+}
+
+// SetRedactions sets the secret values that are replaced
+// with "********" in the output.
+func (w *PrefixWriter) SetRedactions(vals []string) {
+	w.Lock()
+	defer w.Unlock()
+	w.redact = vals
+}
+
+// AddTap registers a writer that gets a copy of all of the
+// (unprefixed) data written to w.
+func (w *PrefixWriter) AddTap(t io.Writer) {
+	w.Lock()
+	defer w.Unlock()
+	w.taps = append(w.taps, t)
+}
+
+// RemoveTap unregisters a writer added with AddTap.
+func (w *PrefixWriter) RemoveTap(t io.Writer) {
+	w.Lock()
+	defer w.Unlock()
+	for i, tt := range w.taps {
+		if tt == t {
+			w.taps = append(w.taps[:i], w.taps[i+1:]...)
+			return
+		}
+	}
 }
 
 func (w *PrefixWriter) Logln(s string) error {
@@ -94,8 +277,34 @@ func (w *PrefixWriter) Logln(s string) error {
 }
 
 func (w *PrefixWriter) Logf(s string, a ...any) error {
-	p := w.Name + " "
-	c := w.withColor(p + fmt.Sprintf(s, a...))
+	return w.Event(EventLog, s, a...)
+}
+
+// Event logs a lifecycle event. In the text format, that's the message
+// after the process's name, and in the JSON format it's an entry with
+// the event's type.
+func (w *PrefixWriter) Event(typ EventType, s string, a ...any) error {
+	w.Lock()
+	defer w.Unlock()
+
+	// Write any partial line first, so the event is on its own line
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	msg := redact(fmt.Sprintf(s, a...), w.redact)
+	if w.log.Format == LogFormatJSON {
+		return writeEntry(w.Writer, LogEntry{
+			Time:     time.Now(),
+			Proc:     w.Name,
+			Event:    typ,
+			PID:      w.pid,
+			Restarts: w.restarts,
+			Message:  msg,
+		})
+	}
+
+	c := w.withColor(w.prefix(false) + msg)
 	b := []byte(c)
 	_, err := w.Writer.Write(b)
 	return err
@@ -104,6 +313,9 @@ func (w *PrefixWriter) Logf(s string, a ...any) error {
 func (w *PrefixWriter) Close() error {
 	w.Lock()
 	defer w.Unlock()
+	if err := w.flush(); err != nil {
+		return err
+	}
 	if c, ok := w.Writer.(io.Closer); ok {
 		return c.Close()
 	}
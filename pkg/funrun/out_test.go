@@ -0,0 +1,304 @@
+package funrun
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// testOutput is a buffer that's safe to read while a
+// PrefixWriter's timer might be writing to it.
+type testOutput struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
+func (o *testOutput) Write(p []byte) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+	return o.buf.Write(p)
+}
+
+// lines returns the lines written so far, without colors.
+func (o *testOutput) lines() []string {
+	o.Lock()
+	defer o.Unlock()
+	s := ansiEscape.ReplaceAllString(o.buf.String(), "")
+	if s == "" {
+		return nil
+	}
+	return strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func newTestWriter(name, stream string, out *testOutput) *PrefixWriter {
+	return NewPrefixWriter(name, stream, len(name), 0, out)
+}
+
+// write writes chunks to a writer, failing the test on an error.
+func write(t *testing.T, w *PrefixWriter, chunks ...string) {
+	t.Helper()
+	for _, c := range chunks {
+		n, err := w.Write([]byte(c))
+		if err != nil {
+			t.Fatalf("Write(%q) failed: %v", c, err)
+		}
+		if n != len(c) {
+			t.Fatalf("Write(%q) = %d, want %d", c, n, len(c))
+		}
+	}
+}
+
+func checkLines(t *testing.T, out *testOutput, want ...string) {
+	t.Helper()
+	got := out.lines()
+	for i := range got {
+		got[i] = strings.TrimSuffix(got[i], "\n")
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines:\n  %q\nwant:\n  %q", got, want)
+	}
+}
+
+func TestPrefixWriterLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name:   "one line",
+			chunks: []string{"hello\n"},
+			want:   []string{"a | hello"},
+		},
+		{
+			name:   "multi-line chunk",
+			chunks: []string{"one\ntwo\nthree\n"},
+			want:   []string{"a | one", "a | two", "a | three"},
+		},
+		{
+			name:   "partial line completed later",
+			chunks: []string{"par", "tial\nnext", " line\n"},
+			want:   []string{"a | partial", "a | next line"},
+		},
+		{
+			name:   "empty lines",
+			chunks: []string{"\n\nx\n"},
+			want:   []string{"a | ", "a | ", "a | x"},
+		},
+		{
+			name:   "carriage return overwrites",
+			chunks: []string{"10%\r50%\r100%\n"},
+			want:   []string{"a | 100%"},
+		},
+		{
+			name:   "progress across writes",
+			chunks: []string{"\r10%", "\r50%", "\r100%", "\n"},
+			want:   []string{"a | 100%"},
+		},
+		{
+			name:   "CRLF",
+			chunks: []string{"one\r\ntwo\r\n"},
+			want:   []string{"a | one", "a | two"},
+		},
+		{
+			name:   "CRLF split across writes",
+			chunks: []string{"one\r", "\ntwo\r", "\n"},
+			want:   []string{"a | one", "a | two"},
+		},
+		{
+			name:   "trailing carriage return",
+			chunks: []string{"done\r\r\n"},
+			want:   []string{"a | done"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &testOutput{}
+			w := newTestWriter("a", "stdout", out)
+			write(t, w, tt.chunks...)
+			checkLines(t, out, tt.want...)
+		})
+	}
+}
+
+func TestPrefixWriterFlushDelay(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+	write(t, w, "Password: ")
+
+	// The partial line is held on to for a bit...
+	checkLines(t, out)
+
+	// ...and then written on its own
+	deadline := time.Now().Add(10 * flushDelay)
+	for len(out.lines()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(flushDelay / 4)
+	}
+	checkLines(t, out, "a | Password: ")
+
+	// The rest of the line comes out on a new one
+	write(t, w, "ok\n")
+	checkLines(t, out, "a | Password: ", "a | ok")
+}
+
+func TestPrefixWriterFlushDelayResets(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+
+	// A line that keeps growing (e.g. a progress indicator) isn't
+	// written until it stops, even if that's after the flush delay
+	write(t, w, "Downloading")
+	for i := 0; i < 4; i++ {
+		time.Sleep(flushDelay / 2)
+		write(t, w, ".")
+	}
+	write(t, w, " done\n")
+	checkLines(t, out, "a | Downloading.... done")
+}
+
+func TestPrefixWriterFlush(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+	write(t, w, "line\nno newline")
+	checkLines(t, out, "a | line")
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, out, "a | line", "a | no newline")
+
+	// Flushing again doesn't write anything
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, out, "a | line", "a | no newline")
+}
+
+func TestPrefixWriterFlushOverwritten(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+	write(t, w, "1%\r2%\r3%")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, out, "a | 3%")
+}
+
+func TestPrefixWriterEventFlushes(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+	write(t, w, "partial")
+	if err := w.Event(EventFinished, "Finished\n"); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, out, "a | partial", "a Finished")
+}
+
+func TestPrefixWriterMaxLineSize(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+
+	// A line that's too long is written as it is...
+	long := strings.Repeat("x", maxLineSize)
+	write(t, w, long[:maxLineSize/2], long[maxLineSize/2:])
+	checkLines(t, out, "a | "+long)
+
+	// ...and anything shorter is still held on to
+	write(t, w, "short")
+	checkLines(t, out, "a | "+long)
+	w.Flush()
+	checkLines(t, out, "a | "+long, "a | short")
+}
+
+func TestPrefixWriterStderr(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stderr", out)
+	write(t, w, "oops\n")
+	checkLines(t, out, "a ! oops")
+}
+
+func TestPrefixWriterInterleaving(t *testing.T) {
+	out := &testOutput{}
+	a := newTestWriter("a", "stdout", out)
+	b := newTestWriter("b", "stdout", out)
+
+	// Partial lines from different writers don't get mixed...
+	write(t, a, "hel")
+	write(t, b, "wor")
+	write(t, a, "lo\n")
+	write(t, b, "ld\n")
+	checkLines(t, out, "a | hello", "b | world")
+
+	// ...even when they're written at the same time
+	out = &testOutput{}
+	const n = 200
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		w := newTestWriter(name, "stdout", out)
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				line := fmt.Sprintf("%s-line-%d\n", name, i)
+				for len(line) > 0 {
+					k := 3
+					if k > len(line) {
+						k = len(line)
+					}
+					w.Write([]byte(line[:k]))
+					line = line[k:]
+				}
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	lines := out.lines()
+	if len(lines) != 3*n {
+		t.Fatalf("got %d lines, want %d", len(lines), 3*n)
+	}
+	seen := make(map[string]int)
+	for _, l := range lines {
+		var name, name2 string
+		var i int
+		if _, err := fmt.Sscanf(l, "%s | %1s-line-%d\n", &name, &name2, &i); err != nil || name != name2 {
+			t.Fatalf("mixed up line %q", l)
+		}
+		if i != seen[name] {
+			t.Fatalf("line %q out of order (want %d)", l, seen[name])
+		}
+		seen[name]++
+	}
+}
+
+func TestPrefixWriterRedacts(t *testing.T) {
+	out := &testOutput{}
+	w := newTestWriter("a", "stdout", out)
+	w.SetRedactions([]string{"hunter2"})
+
+	// Even when the secret is split across writes
+	write(t, w, "password is hun", "ter2\n")
+
+	// ...and in fun-run's own messages about the process
+	if err := w.Event(EventStartFailed, "Error starting command: %s\n", `exec: "hunter2": not found`); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, out, "a | password is ********", `a Error starting command: exec: "********": not found`)
+
+	out = &testOutput{}
+	w = newTestWriter("a", "stdout", out)
+	w.SetRedactions([]string{"hunter2"})
+	w.SetLogConf(LogConf{Format: LogFormatJSON})
+	if err := w.Event(EventStartFailed, "hunter2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(out.lines(), ""); strings.Contains(got, "hunter2") || !strings.Contains(got, "********") {
+		t.Errorf("got %q, want the secret redacted", got)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package funrun
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcGroup makes the command start in its own process group,
+// so that it (and any processes it starts) can be signalled together.
+func setProcGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends a signal to every process in the
+// process's group.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, s)
+}
+
+// killGroup kills every process in the process's group.
+func killGroup(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+// groupAlive returns true if any processes in the
+// process's group are still running.
+func groupAlive(p *os.Process) bool {
+	err := syscall.Kill(-p.Pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,85 @@
+//go:build !windows
+
+package funrun
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// procAlive reports whether a process is still running (a zombie
+// waiting to be reaped counts as gone).
+func procAlive(pid int) bool {
+	if b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); err == nil {
+		// The state comes after the command's name, which is in parens
+		s := string(b)
+		if i := strings.LastIndexByte(s, ')'); i >= 0 && i+2 < len(s) {
+			return s[i+2] != 'Z'
+		}
+		return true
+	} else if os.IsNotExist(err) {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// waitForFile waits for a file to be written and returns its contents.
+func waitForFile(t *testing.T, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if b, err := os.ReadFile(path); err == nil && strings.HasSuffix(string(b), "\n") {
+			return strings.TrimSpace(string(b))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", path)
+	return ""
+}
+
+func TestShutdownKillsDescendants(t *testing.T) {
+	// The process starts a child that ignores SIGTERM (and doesn't
+	// hold on to its output), and records the child's PID
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	conf := mustReadConf(t, `
+procs:
+  - name: parent
+    cmd: sh
+    args:
+      - -c
+      - sh -c 'trap "" TERM; echo $$$$ > `+pidFile+`; exec sleep 47' >/dev/null 2>&1 & sleep 30
+    stop_timeout: 2s
+`)
+	m, errs := startManager(t, conf)
+
+	pid, err := strconv.Atoi(waitForFile(t, pidFile))
+	if err != nil {
+		t.Fatalf("invalid child PID: %v", err)
+	}
+	t.Cleanup(func() { syscall.Kill(pid, syscall.SIGKILL) })
+
+	m.Shutdown()
+	select {
+	case <-errs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the manager to shut down")
+	}
+
+	// SIGKILL is delivered asynchronously, so give it a moment (but
+	// not long enough for a kill that's still pending after Run has
+	// returned to count)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for procAlive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d is still running after shutdown", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package funrun
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcGroup is a no-op on windows.
+func setProcGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends a signal to the process. Windows doesn't
+// have process groups, so only the process itself is signalled.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// killGroup kills the process.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// groupAlive always returns false on windows, since the process's
+// children can't be tracked.
+func groupAlive(p *os.Process) bool {
+	return false
+}
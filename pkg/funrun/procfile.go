@@ -0,0 +1,146 @@
+package funrun
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultBasePort is the first port assigned to the processes in a
+// Procfile (incremented by 100 for each process), as foreman does,
+// unless the .env file sets a PORT.
+const defaultBasePort = 5000
+
+// procfileLine matches a "name: command" line of a Procfile.
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// IsProcfile reports whether a config path looks like a Procfile
+// (e.g. "Procfile" or "Procfile.dev").
+func IsProcfile(path string) bool {
+	base := filepath.Base(path)
+	return base == "Procfile" || strings.HasPrefix(base, "Procfile.")
+}
+
+// ParseProcfile parses a Procfile into a config, with one process
+// per "name: command" line. Each command is run with the shell.
+func ParseProcfile(r io.Reader) (*Conf, error) {
+	var conf Conf
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := procfileLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected \"name: command\"", n)
+		}
+		conf.Procs = append(conf.Procs, &ProcConf{
+			Name: m[1],
+			Cmds: []string{m[2]},
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// ParseDotEnv parses a .env file of "KEY=value" lines. Lines can start
+// with "export", values can be single-quoted (taken literally) or
+// double-quoted (with escapes), and "#" starts a comment.
+func ParseDotEnv(r io.Reader) (map[string]string, error) {
+	envs := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		// Split the key and value...
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"KEY=value\"", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		val := strings.TrimSpace(line[i+1:])
+
+		// ...and unquote the value
+		switch {
+		case strings.HasPrefix(val, `"`):
+			j := strings.LastIndexByte(val, '"')
+			if j == 0 {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+			s, err := strconv.Unquote(val[:j+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid value: %w", n, err)
+			}
+			val = s
+		case strings.HasPrefix(val, "'"):
+			j := strings.LastIndexByte(val, '\'')
+			if j == 0 {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+			val = val[1:j]
+		default:
+			if j := strings.Index(val, " #"); j >= 0 {
+				val = strings.TrimSpace(val[:j])
+			}
+		}
+		envs[key] = val
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// ReadDotEnv reads a .env file. If the file doesn't exist, it
+// returns an empty map.
+func ReadDotEnv(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	envs, err := ParseDotEnv(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse env file %q: %w", path, err)
+	}
+	return envs, nil
+}
+
+// ProcfileConf converts a Procfile (and the variables from its .env
+// file) into a config. Like foreman, each process gets its own PORT,
+// counting up by 100 from a base port (the .env file's PORT, or 5000).
+func ProcfileConf(b []byte, envs map[string]string) (*Conf, error) {
+	conf, err := ParseProcfile(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	base := defaultBasePort
+	if s, ok := envs["PORT"]; ok {
+		if base, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid PORT %q in .env file (must be a number)", s)
+		}
+	}
+	for i, p := range conf.Procs {
+		p.Envs = make(map[string]string, len(envs)+1)
+		for k, v := range envs {
+			p.Envs[k] = v
+		}
+		p.Envs["PORT"] = strconv.Itoa(base + 100*i)
+	}
+	return conf, nil
+}
@@ -0,0 +1,57 @@
+package funrun
+
+import (
+	"testing"
+)
+
+func TestProcfileConfPorts(t *testing.T) {
+	procfile := []byte("web: rails server -p $PORT\nworker: sidekiq\n# comment\n\nclock: clockwork\n")
+	tests := []struct {
+		name string
+		envs map[string]string
+		want []string
+		err  bool
+	}{
+		{name: "default base port", want: []string{"5000", "5100", "5200"}},
+		{name: "base port from .env", envs: map[string]string{"PORT": "3000"}, want: []string{"3000", "3100", "3200"}},
+		{name: "invalid PORT", envs: map[string]string{"PORT": "http"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := ProcfileConf(procfile, tt.envs)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conf.Procs) != len(tt.want) {
+				t.Fatalf("got %d processes, want %d", len(conf.Procs), len(tt.want))
+			}
+			for i, p := range conf.Procs {
+				if got := p.Envs["PORT"]; got != tt.want[i] {
+					t.Errorf("%s: PORT = %q, want %q", p.Name, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProcfileConfKeepsEnvs(t *testing.T) {
+	envs := map[string]string{"RAILS_ENV": "development"}
+	conf, err := ProcfileConf([]byte("web: rails server\nworker: sidekiq\n"), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range conf.Procs {
+		if got := p.Envs["RAILS_ENV"]; got != "development" {
+			t.Errorf("%s: RAILS_ENV = %q, want %q", p.Name, got, "development")
+		}
+	}
+	if _, ok := envs["PORT"]; ok {
+		t.Error("ProcfileConf modified the .env variables")
+	}
+}
@@ -0,0 +1,115 @@
+package funrun
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validateProfiles checks that each profile only lists
+// processes that exist.
+func (c *Conf) validateProfiles(v *validator) {
+	names := make(map[string]bool)
+	for _, p := range c.Procs {
+		if p != nil {
+			names[p.Name] = true
+		}
+	}
+
+	profiles := getKey(v.src.root, "profiles")
+	for _, name := range c.ProfileNames() {
+		n := getKey(profiles, name)
+		if len(c.Profiles[name]) == 0 {
+			v.add(n, "profile %q is empty", name)
+		}
+		for j, p := range c.Profiles[name] {
+			if !names[p] {
+				pn := n
+				if n != nil && n.Kind == yaml.SequenceNode && j < len(n.Content) {
+					pn = n.Content[j]
+				}
+				v.add(pn, "profile %q includes unknown process %q", name, p)
+			}
+		}
+	}
+}
+
+// Select narrows the config down to the named processes and the
+// processes in the named profiles, along with everything they depend
+// on. The processes keep their order. If nothing is named, the
+// config is left as it is.
+func (c *Conf) Select(procs, profiles []string) error {
+	if len(procs) == 0 && len(profiles) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]*ProcConf)
+	for _, p := range c.Procs {
+		byName[p.Name] = p
+	}
+
+	// Get the processes that were asked for...
+	want := append([]string{}, procs...)
+	for _, name := range profiles {
+		ps, ok := c.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q (%s)", name, c.describeProfiles())
+		}
+		want = append(want, ps...)
+	}
+
+	// ...and everything they depend on
+	selected := make(map[string]bool)
+	var add func(name string) error
+	add = func(name string) error {
+		if selected[name] {
+			return nil
+		}
+		p, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown process %q", name)
+		}
+		selected[name] = true
+		for _, d := range p.DependsOn {
+			if err := add(d.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range want {
+		if err := add(name); err != nil {
+			return err
+		}
+	}
+
+	var keep []*ProcConf
+	for _, p := range c.Procs {
+		if selected[p.Name] {
+			keep = append(keep, p)
+		}
+	}
+	c.Procs = keep
+	return nil
+}
+
+// ProfileNames returns the names of the config's
+// profiles, sorted.
+func (c *Conf) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describeProfiles lists the profiles, for error messages.
+func (c *Conf) describeProfiles() string {
+	if len(c.Profiles) == 0 {
+		return "no profiles are defined"
+	}
+	return "profiles: " + strings.Join(c.ProfileNames(), ", ")
+}
@@ -0,0 +1,248 @@
+package funrun
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultReadyInterval is the time between readiness checks
+// if an interval isn't set.
+const defaultReadyInterval = time.Second
+
+// ReadyConf configures the checks used to decide when a running
+// process is ready (e.g. when a database is accepting connections).
+//
+// If more than one check is set, they all need to pass before the
+// process is considered ready.
+type ReadyConf struct {
+	TCP      string   `json:"tcp,omitempty" yaml:"tcp,omitempty"`           // Address (or port on localhost) that must accept TCP connections
+	HTTP     string   `json:"http,omitempty" yaml:"http,omitempty"`         // URL that must respond to a GET request
+	Status   int      `json:"status,omitempty" yaml:"status,omitempty"`     // Expected HTTP status code (default: any 2xx)
+	Exec     string   `json:"exec,omitempty" yaml:"exec,omitempty"`         // Shell command that must exit successfully
+	Log      string   `json:"log,omitempty" yaml:"log,omitempty"`           // Regex that must match a line of the process's stdout
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"` // Time between checks (default: 1s)
+	Timeout  Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`   // Max time to wait for the process to be ready (default: no limit)
+}
+
+// validate checks that the readiness config is usable.
+func (r *ReadyConf) validate() error {
+	if r.TCP == "" && r.HTTP == "" && r.Exec == "" && r.Log == "" {
+		return fmt.Errorf("no readiness checks set")
+	}
+	if r.Log != "" {
+		if _, err := regexp.Compile(r.Log); err != nil {
+			return fmt.Errorf("invalid log pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// tcpAddr returns the address for the TCP check, defaulting
+// to localhost if only a port is given.
+func (r *ReadyConf) tcpAddr() string {
+	if !strings.Contains(r.TCP, ":") {
+		return "localhost:" + r.TCP
+	}
+	if strings.HasPrefix(r.TCP, ":") {
+		return "localhost" + r.TCP
+	}
+	return r.TCP
+}
+
+// logProbe watches a process's output for a line
+// that matches a pattern.
+type logProbe struct {
+	re      *regexp.Regexp
+	buf     []byte
+	matched bool
+	sync.Mutex
+}
+
+func newLogProbe(pattern string) (*logProbe, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &logProbe{re: re}, nil
+}
+
+func (p *logProbe) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	// Once it's matched, we don't need to keep looking
+	if p.matched {
+		return len(b), nil
+	}
+
+	// Check each complete line...
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if p.re.Match(p.buf[:i]) {
+			p.matched = true
+			p.buf = nil
+			return len(b), nil
+		}
+		p.buf = p.buf[i+1:]
+	}
+
+	// ...and the partial line, in case it never gets a newline
+	if p.re.Match(p.buf) {
+		p.matched = true
+		p.buf = nil
+	}
+	return len(b), nil
+}
+
+func (p *logProbe) isMatched() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.matched
+}
+
+// checkReady runs each of the configured readiness checks once,
+// returning the first failure.
+func (c *Command) checkReady(ctx context.Context, lp *logProbe) error {
+	r := c.conf.Ready
+
+	// Don't let a single check hang for longer than the interval
+	wait := time.Duration(r.Interval)
+	if wait <= 0 {
+		wait = defaultReadyInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	if r.TCP != "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", r.tcpAddr())
+		if err != nil {
+			return fmt.Errorf("tcp check failed: %w", err)
+		}
+		conn.Close()
+	}
+
+	if r.HTTP != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.HTTP, nil)
+		if err != nil {
+			return fmt.Errorf("http check failed: %w", err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("http check failed: %w", err)
+		}
+		res.Body.Close()
+
+		ok := res.StatusCode >= 200 && res.StatusCode < 300
+		if r.Status != 0 {
+			ok = res.StatusCode == r.Status
+		}
+		if !ok {
+			return fmt.Errorf("http check failed: got status %d", res.StatusCode)
+		}
+	}
+
+	if r.Exec != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", r.Exec)
+		cmd.Dir = c.conf.WorkDir
+		cmd.Env = c.fmtEnvSlice()
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("exec check failed: %w", err)
+		}
+	}
+
+	if lp != nil && !lp.isMatched() {
+		return fmt.Errorf("log check failed: no line matching %q", r.Log)
+	}
+
+	return nil
+}
+
+// waitReady runs the readiness checks until they all pass, the
+// timeout is reached, or the context is cancelled (in which case
+// it returns nil).
+func (c *Command) waitReady(ctx context.Context, lp *logProbe) error {
+	r := c.conf.Ready
+
+	// Set up the timers...
+	interval := time.Duration(r.Interval)
+	if interval <= 0 {
+		interval = defaultReadyInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var timeout <-chan time.Time
+	if r.Timeout > 0 {
+		t := time.NewTimer(time.Duration(r.Timeout))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	// Check until ready...
+	for {
+		err := c.checkReady(ctx, lp)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout:
+			return fmt.Errorf("not ready after %s: %w", r.Timeout, err)
+		case <-tick.C:
+		}
+	}
+}
+
+// markReady moves a running command into the ready state and
+// lets anyone waiting on it know.
+func (c *Command) markReady() {
+	c.Lock()
+	if c.status == CmdRunning {
+		c.status = CmdReady
+	}
+	c.Unlock()
+	c.ready.fire()
+}
+
+// startReadyCheck checks whether the running command is ready in
+// the background. The returned channel receives the result once
+// the check is finished. If the check times out, the command is
+// cancelled using the given cancel function.
+func (c *Command) startReadyCheck(ctx context.Context, cancel context.CancelFunc, lp *logProbe) <-chan error {
+	res := make(chan error, 1)
+
+	// Without checks, running means ready
+	if c.conf.Ready == nil {
+		c.markReady()
+		res <- nil
+		return res
+	}
+
+	go func() {
+		err := c.waitReady(ctx, lp)
+		if err != nil {
+			c.wout.Event(EventReadyFailed, "Readiness check failed: %s\n", err)
+			cancel()
+		} else if ctx.Err() == nil {
+			c.markReady()
+			c.wout.Event(EventReady, "Ready\n")
+		}
+		res <- err
+	}()
+	return res
+}
@@ -0,0 +1,194 @@
+package funrun
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRestartDelay    = 250 * time.Millisecond // Base delay before a restart
+	defaultRestartMaxDelay = 30 * time.Second       // Max delay between restarts
+	defaultRestartWindow   = time.Minute            // Window used to count restarts
+	minRunTime             = time.Second            // Runs shorter than this are backed off, even if they succeed
+)
+
+// CrashLoopError is the error recorded when a command has been
+// restarted too many times within its restart window.
+type CrashLoopError struct {
+	Restarts int           // Number of restarts within the window
+	Window   time.Duration // The restart window
+	Err      error         // The error from the last run (if any)
+}
+
+func (e *CrashLoopError) Error() string {
+	s := fmt.Sprintf("crash loop: restarted %d times in %s", e.Restarts, e.Window)
+	if e.Err != nil {
+		s += fmt.Sprintf(" (last error: %s)", e.Err)
+	}
+	return s
+}
+
+func (e *CrashLoopError) Unwrap() error {
+	return e.Err
+}
+
+// isSuccessCode returns true if the exit code means the command
+// was successful. Zero is always a success.
+func (c *Command) isSuccessCode(code int) bool {
+	return code == 0 || containsInt(c.conf.SuccessExitCodes, code)
+}
+
+// shouldRestart decides whether the command should be restarted
+// after it's exited, based on its restart policy and exit code.
+func (c *Command) shouldRestart() bool {
+	code, _ := c.ExitStatus()
+	failed := c.Error() != nil
+
+	switch c.conf.Restart {
+	case RestartAlways:
+		return !containsInt(c.conf.NoRestartOnExitCodes, code)
+
+	case RestartOnFail:
+		if !failed || containsInt(c.conf.NoRestartOnExitCodes, code) {
+			return false
+		}
+		if len(c.conf.RestartOnExitCodes) > 0 {
+			return containsInt(c.conf.RestartOnExitCodes, code)
+		}
+		return true
+
+	default:
+		return false
+	}
+}
+
+func containsInt(s []int, n int) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// restartWindow returns the window used to count restarts.
+func (c *Command) restartWindow() time.Duration {
+	if c.conf.RestartWindow > 0 {
+		return time.Duration(c.conf.RestartWindow)
+	}
+	return defaultRestartWindow
+}
+
+// backoff returns how long to wait before restarting, given the
+// number of runs in a row that have failed. The delay doubles with
+// each failure (up to the max delay) and is jittered by +/-20%.
+func (c *Command) backoff(failures int) time.Duration {
+	base := defaultRestartDelay
+	if c.conf.RestartDelay > 0 {
+		base = time.Duration(c.conf.RestartDelay)
+	}
+	max := defaultRestartMaxDelay
+	if c.conf.RestartMaxDelay > 0 {
+		max = time.Duration(c.conf.RestartMaxDelay)
+	}
+
+	d := base
+	for i := 0; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	// Add some jitter so procs failing together don't
+	// restart in lock-step
+	j := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(d) * j)
+}
+
+// prepRestart records a restart and returns how long to wait before
+// it. If the command has been restarted too many times within its
+// restart window, a CrashLoopError is returned instead.
+func (c *Command) prepRestart(failed bool) (time.Duration, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	// Track failures in a row. Runs that exit straight away count as
+	// failures too, so they're backed off rather than restarted in a
+	// tight loop...
+	if failed || c.lastRun < minRunTime {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+
+	// Drop the restarts that have fallen out of the window...
+	now := time.Now()
+	window := c.restartWindow()
+	recent := c.restartTimes[:0]
+	for _, t := range c.restartTimes {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	c.restartTimes = recent
+
+	// Have there been too many?
+	if c.conf.MaxRestarts > 0 && len(c.restartTimes) >= c.conf.MaxRestarts {
+		return 0, &CrashLoopError{
+			Restarts: len(c.restartTimes),
+			Window:   window,
+			Err:      c.err,
+		}
+	}
+
+	// Record the restart...
+	c.restartTimes = append(c.restartTimes, now)
+	c.restarts++
+
+	// Only back off after failures (or runs that were too short)
+	if c.failures == 0 {
+		return time.Duration(c.conf.RestartDelay), nil
+	}
+	return c.backoff(c.failures - 1), nil
+}
+
+// waitToRestart waits until the command should be restarted. It
+// returns false if the command shouldn't be restarted, either because
+// it's crash looping (in which case it's marked as failed) or because
+// it was stopped while waiting.
+func (c *Command) waitToRestart(failed bool) bool {
+	d, err := c.prepRestart(failed)
+	if err != nil {
+		c.setError(err)
+		c.setStatus(CmdFailed)
+		c.wout.Event(EventGivingUp, "Giving up: %s\n", err)
+		return false
+	}
+	n := c.Restarts()
+	c.wout.setRestarts(n)
+	c.werr.setRestarts(n)
+
+	if d <= 0 {
+		c.wout.Event(EventRestarting, "Restarting...\n")
+		return true
+	}
+
+	c.wout.Event(EventRestarting, "Restarting in %s...\n", d.Round(time.Millisecond))
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.stoppingEvent().done():
+		return false
+	}
+}
+
+// Restarts returns the number of times the command has been restarted.
+func (c *Command) Restarts() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.restarts
+}
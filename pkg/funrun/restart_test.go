@@ -0,0 +1,145 @@
+package funrun
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkJitter checks that a delay is within the jitter of another.
+func checkJitter(t *testing.T, what string, got, want time.Duration) {
+	t.Helper()
+	lo, hi := time.Duration(float64(want)*0.8), time.Duration(float64(want)*1.2)
+	if got < lo || got > hi {
+		t.Errorf("%s = %s, want %s (+/-20%%)", what, got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     ProcConf
+		failures int
+		want     time.Duration
+	}{
+		{name: "default base", failures: 0, want: defaultRestartDelay},
+		{name: "doubles", failures: 1, want: 2 * defaultRestartDelay},
+		{name: "doubles again", failures: 3, want: 8 * defaultRestartDelay},
+		{name: "default cap", failures: 20, want: defaultRestartMaxDelay},
+		{
+			name:     "custom base",
+			conf:     ProcConf{RestartDelay: Duration(time.Second)},
+			failures: 2,
+			want:     4 * time.Second,
+		},
+		{
+			name:     "custom cap",
+			conf:     ProcConf{RestartDelay: Duration(time.Second), RestartMaxDelay: Duration(3 * time.Second)},
+			failures: 2,
+			want:     3 * time.Second,
+		},
+		{
+			name:     "base above the cap",
+			conf:     ProcConf{RestartDelay: Duration(time.Minute), RestartMaxDelay: Duration(time.Second)},
+			failures: 0,
+			want:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCommand(&tt.conf)
+			for i := 0; i < 10; i++ {
+				checkJitter(t, "backoff", c.backoff(tt.failures), tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepRestartBacksOff(t *testing.T) {
+	c := NewCommand(&ProcConf{Name: "a", RestartDelay: Duration(100 * time.Millisecond)})
+
+	// Failed runs back off more each time...
+	for i, want := range []time.Duration{100, 200, 400} {
+		d, err := c.prepRestart(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkJitter(t, fmt.Sprintf("delay after failure %d", i+1), d, want*time.Millisecond)
+	}
+
+	// ...and so do runs that succeed but exit straight away...
+	c.lastRun = 10 * time.Millisecond
+	d, err := c.prepRestart(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJitter(t, "delay after a quick run", d, 800*time.Millisecond)
+
+	// ...but a run that lasted resets the backoff
+	c.lastRun = time.Minute
+	if d, err = c.prepRestart(false); err != nil || d != 100*time.Millisecond {
+		t.Errorf("delay after a long run = %s, %v, want 100ms", d, err)
+	}
+	if n := c.Restarts(); n != 5 {
+		t.Errorf("Restarts() = %d, want 5", n)
+	}
+}
+
+func TestPrepRestartQuickSuccessNoDelay(t *testing.T) {
+	// Without a restart delay, a process that keeps exiting 0 straight
+	// away still gets a delay (rather than restarting in a tight loop)
+	c := NewCommand(&ProcConf{Name: "a", Restart: RestartAlways})
+	for i := 0; i < 3; i++ {
+		d, err := c.prepRestart(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkJitter(t, "delay", d, defaultRestartDelay<<i)
+	}
+}
+
+func TestPrepRestartCrashLoop(t *testing.T) {
+	c := NewCommand(&ProcConf{Name: "a", MaxRestarts: 2, RestartWindow: Duration(time.Hour)})
+	last := errors.New("exit status 1")
+	c.setError(last)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.prepRestart(true); err != nil {
+			t.Fatalf("restart %d: %v", i+1, err)
+		}
+	}
+	_, err := c.prepRestart(true)
+	var cle *CrashLoopError
+	if !errors.As(err, &cle) {
+		t.Fatalf("prepRestart() error = %v, want a *CrashLoopError", err)
+	}
+	if cle.Restarts != 2 || cle.Window != time.Hour {
+		t.Errorf("got %d restarts in %s, want 2 in 1h", cle.Restarts, cle.Window)
+	}
+	if !errors.Is(err, last) {
+		t.Errorf("the CrashLoopError doesn't wrap the last run's error")
+	}
+	want := "crash loop: restarted 2 times in 1h0m0s (last error: exit status 1)"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	// Restarts outside of the window don't count
+	c.Lock()
+	for i := range c.restartTimes {
+		c.restartTimes[i] = c.restartTimes[i].Add(-2 * time.Hour)
+	}
+	c.Unlock()
+	if _, err := c.prepRestart(true); err != nil {
+		t.Errorf("prepRestart() after the window = %v, want no error", err)
+	}
+}
+
+func TestCrashLoopErrorWithoutErr(t *testing.T) {
+	err := &CrashLoopError{Restarts: 5, Window: time.Minute}
+	if got := err.Error(); strings.Contains(got, "last error") {
+		t.Errorf("Error() = %q, want no last error", got)
+	}
+}
@@ -0,0 +1,330 @@
+package funrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaURI is the JSON Schema version the config schema uses.
+const SchemaURI = "http://json-schema.org/draft-07/schema#"
+
+// durationPattern matches the durations that can be written as
+// strings (e.g. "30", "500ms" or "1h30m").
+const durationPattern = `^([0-9]+(\.[0-9]+)?|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"` // A type name, or a list of them
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"` // false, or the schema for the values
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+//go:generate go run gendocs.go
+
+// sourceDocs holds the doc comments from the config types' source
+// (see gendocs.go, which writes them to schema_docs.go).
+type sourceDocs struct {
+	types  map[string]string            // Type name -> doc comment
+	fields map[string]map[string]string // Type name -> field name -> comment
+	enums  map[string][]string          // Type name -> constant values
+}
+
+// ConfSchema returns the JSON Schema for config files.
+func ConfSchema() *Schema {
+	g := &schemaGen{docs: docs, defs: make(map[string]*Schema)}
+	g.structDef(reflect.TypeOf(Conf{}))
+	root := g.defs["Conf"]
+	delete(g.defs, "Conf")
+
+	root.Schema = SchemaURI
+	root.Title = "fun-run config"
+	root.Definitions = g.defs
+	return root
+}
+
+// MarshalSchema returns the config schema as indented JSON.
+func MarshalSchema() ([]byte, error) {
+	return json.MarshalIndent(ConfSchema(), "", "  ")
+}
+
+// schemaGen builds a schema from the config types.
+type schemaGen struct {
+	docs *sourceDocs
+	defs map[string]*Schema // Schemas for the struct types
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// schemaFor returns the schema for a type. Struct types are added
+// to the definitions and referenced.
+func (g *schemaGen) schemaFor(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with their own formats...
+	switch t {
+	case durationType:
+		return &Schema{OneOf: []*Schema{
+			{Type: "string", Pattern: durationPattern},
+			{Type: "number", Minimum: new(float64)},
+		}}
+	case reflect.TypeOf(StringList{}):
+		return &Schema{OneOf: []*Schema{
+			{Type: "string"},
+			{Type: "array", Items: &Schema{Type: "string"}},
+		}}
+	case reflect.TypeOf(Dependency{}):
+		g.structDef(t)
+		return &Schema{OneOf: []*Schema{
+			{Type: []string{"string", "number", "boolean"}},
+			{Ref: "#/definitions/" + t.Name()},
+		}}
+	}
+	if vals, ok := g.docs.enums[t.Name()]; ok && t.Kind() == reflect.String {
+		return &Schema{Type: "string", Enum: vals}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		g.structDef(t)
+		return &Schema{Ref: "#/definitions/" + t.Name()}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.String:
+		// Like the yaml decoder, accept any scalar (e.g. a port
+		// number) where a string is expected
+		return &Schema{Type: []string{"string", "number", "boolean"}}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// None of the config's numbers (e.g. exit codes, status codes
+		// or limits) can be negative
+		return &Schema{Type: "integer", Minimum: new(float64)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	return &Schema{}
+}
+
+// structDef adds the definition for a struct type.
+func (g *schemaGen) structDef(t reflect.Type) {
+	name := t.Name()
+	if _, ok := g.defs[name]; ok {
+		return
+	}
+	s := &Schema{
+		Type:                 "object",
+		Description:          g.docs.types[name],
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+	g.defs[name] = s
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		key := tag[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+
+		fs := g.schemaFor(f.Type)
+		if desc := g.docs.fields[name][f.Name]; desc != "" {
+			if fs.Ref != "" {
+				// Keywords next to a $ref are ignored (in draft-07)
+				fs = &Schema{Description: desc, OneOf: []*Schema{fs}}
+			} else {
+				fs.Description = desc
+			}
+		}
+		s.Properties[key] = fs
+
+		// Fields that can't be left out are required
+		if len(tag) == 1 || !strings.Contains(tag[1], "omitempty") {
+			s.Required = append(s.Required, key)
+		}
+	}
+}
+
+// ValidateSchema checks the config files (merged together, like
+// ReadConfFormat) against the config schema. Every problem is
+// returned in a *ConfError.
+func ValidateSchema(stdinFormat Format, paths ...string) error {
+	src, err := loadConfSource(paths, stdinFormat)
+	if err != nil {
+		return err
+	}
+	root := ConfSchema()
+	v := &validator{src: src}
+	v.checkSchema(src.root, root, root)
+	return v.err()
+}
+
+// nodeType returns the JSON type of a yaml node.
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+// typeMatches reports whether a node matches a schema's type.
+func typeMatches(n *yaml.Node, s *Schema) bool {
+	var types []string
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []string:
+		types = t
+	default:
+		return true
+	}
+	nt := nodeType(n)
+	for _, t := range types {
+		if t == nt || t == "number" && nt == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeNames describes the types a schema allows.
+func typeNames(s *Schema) string {
+	if ts, ok := s.Type.([]string); ok {
+		return strings.Join(ts, " or ")
+	}
+	return fmt.Sprint(s.Type)
+}
+
+// resolve follows a schema's reference (if it has one).
+func (s *Schema) resolve(root *Schema) *Schema {
+	if s.Ref == "" {
+		return s
+	}
+	return root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+}
+
+// checkSchema reports the ways in which a node doesn't match a schema.
+func (v *validator) checkSchema(n *yaml.Node, s, root *Schema) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	s = s.resolve(root)
+
+	// Empty values are treated like missing ones
+	if nodeType(n) == "null" {
+		return
+	}
+
+	// Pick the first alternative with the right type...
+	if len(s.OneOf) > 0 {
+		var want []string
+		for _, alt := range s.OneOf {
+			alt = alt.resolve(root)
+			if typeMatches(n, alt) {
+				v.checkSchema(n, alt, root)
+				return
+			}
+			want = append(want, typeNames(alt))
+		}
+		v.add(n, "expected %s, got %s", strings.Join(want, " or "), nodeType(n))
+		return
+	}
+
+	// ...and check the type
+	if !typeMatches(n, s) {
+		v.add(n, "expected %s, got %s", typeNames(s), nodeType(n))
+		return
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, val := n.Content[i], n.Content[i+1]
+			seen[k.Value] = true
+			if ps, ok := s.Properties[k.Value]; ok {
+				v.checkSchema(val, ps, root)
+				continue
+			}
+			switch ap := s.AdditionalProperties.(type) {
+			case bool:
+				if !ap {
+					v.add(k, "unknown key %q", k.Value)
+				}
+			case *Schema:
+				v.checkSchema(val, ap, root)
+			}
+		}
+		for _, req := range s.Required {
+			if !seen[req] {
+				v.add(n, "missing required key %q", req)
+			}
+		}
+
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for _, c := range n.Content {
+				v.checkSchema(c, s.Items, root)
+			}
+		}
+
+	case yaml.ScalarNode:
+		if len(s.Enum) > 0 {
+			ok := false
+			for _, e := range s.Enum {
+				ok = ok || e == n.Value
+			}
+			if !ok {
+				v.add(n, "invalid value %q (must be one of: %s)", n.Value, strings.Join(s.Enum, ", "))
+			}
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(n.Value) {
+			v.add(n, "invalid value %q", n.Value)
+		}
+		if s.Minimum != nil {
+			if f, err := strconv.ParseFloat(n.Value, 64); err == nil && f < *s.Minimum {
+				v.add(n, "%s is less than the minimum (%v)", n.Value, *s.Minimum)
+			}
+		}
+	}
+}
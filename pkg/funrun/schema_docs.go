@@ -0,0 +1,127 @@
+// Code generated by gendocs.go; DO NOT EDIT.
+
+package funrun
+
+// docs holds the doc comments from the config types' source.
+var docs = &sourceDocs{
+	types: map[string]string{
+		"Conf":            "Conf is a fun-run config.",
+		"DependCondition": "DependCondition represents the point in a dependency's lifecycle that a dependent process waits for before starting.",
+		"Dependency":      "Dependency is a reference to another process that must reach a given condition before the dependent process can start. In yaml, a dependency can be written either as just the name of the process (using the default \"started\" condition) or as a map with \"name\" and \"condition\" keys.",
+		"Duration":        "Duration is a length of time that can be written in a config file either as a duration string (e.g. \"30s\" or \"5m\") or as a plain number of seconds.",
+		"EventType":       "EventType is the type of a lifecycle event.",
+		"FailurePolicy":   "FailurePolicy represents what the manager does when one of its processes fails.",
+		"LogConf":         "LogConf configures how fun-run writes the processes' output.",
+		"LogEntry":        "LogEntry is a line of output, or a lifecycle event, in the JSON log format.",
+		"LogFormat":       "LogFormat is the format fun-run's output is written in.",
+		"ProcConf":        "ProcConf configures a process.",
+		"ReadyConf":       "ReadyConf configures the checks used to decide when a running process is ready (e.g. when a database is accepting connections). If more than one check is set, they all need to pass before the process is considered ready.",
+		"RestartPolicy":   "RestartPolicy represents the restart behavior of a process.",
+		"SecretConf":      "SecretConf configures where a secret's value comes from. Exactly one of the sources must be set.",
+		"StringList":      "StringList is a list of strings that can also be written as a single string.",
+		"WatchConf":       "WatchConf configures restarting a process when files change.",
+		"logProbe":        "logProbe watches a process's output for a line that matches a pattern.",
+		"watcher":         "watcher restarts a command when the files it watches change.",
+	},
+	fields: map[string]map[string]string{
+		"Conf": {
+			"EnvFile":   "Env files to read variables for every process from (relative to the config file; overridden by Envs)",
+			"Envs":      "Environment variables to set for every process",
+			"Include":   "Config files to merge this one on top of (relative to this one)",
+			"Log":       "Settings for fun-run's output",
+			"OnFailure": "What to do when a process fails (default: continue)",
+			"Procs":     "Processes to run",
+			"Profiles":  "Named sets of processes that can be run together",
+			"Secrets":   "Secrets that processes can use as environment variables, by name",
+			"Socket":    "Path of the control socket, relative to the config file (default: .fun-run.sock)",
+		},
+		"Dependency": {
+			"Condition": "Condition to wait for (default: started)",
+			"Name":      "Name of the process to depend on",
+		},
+		"LogConf": {
+			"Align":      "Pad the processes' names to the same width",
+			"Format":     "Format of the output (default: text)",
+			"PID":        "Show the process's ID in each line",
+			"Stream":     "Show which stream (stdout or stderr) each line is from",
+			"Timestamps": "Show the time before each line",
+		},
+		"LogEntry": {
+			"Event":    "Type of event (for events)",
+			"Message":  "",
+			"PID":      "ID of the process (while it's running)",
+			"Proc":     "Name of the process (if any)",
+			"Restarts": "Number of times the process has been restarted",
+			"Stream":   "\"stdout\" or \"stderr\" (for output)",
+			"Time":     "",
+		},
+		"ProcConf": {
+			"Args":                 "Arguments to pass to the command",
+			"ClearEnvs":            "Clear all environment variables before setting the ones in Envs",
+			"Cmd":                  "Command to run (required unless Cmds is set)",
+			"Cmds":                 "Command to run (required unless Cmd is set)",
+			"DependsOn":            "Processes that must start (or finish) before this one",
+			"EnvFile":              "Env files to read variables from (relative to the config file; overridden by Envs)",
+			"Envs":                 "Environment variables to set",
+			"ExitOnExit":           "Stop all processes (and exit with this process's exit code) when this process exits",
+			"Extends":              "Name of a process to inherit settings from",
+			"MaxRestarts":          "Max restarts within the restart window before giving up (default: no limit)",
+			"Name":                 "Name of the process",
+			"NoRestartOnExitCodes": "Never restart on these exit codes",
+			"Ready":                "Checks used to decide when the process is ready",
+			"Restart":              "Restart policy for the command",
+			"RestartDelay":         "Delay before restarting (doubled after each failure, or run shorter than 1s, in a row; default: 250ms after one)",
+			"RestartMaxDelay":      "Max delay between restarts (default: 30s)",
+			"RestartOnExitCodes":   "With \"on-fail\", only restart on these exit codes",
+			"RestartWindow":        "Window in which restarts are counted for max_restarts (default: 1m)",
+			"Secrets":              "Secrets (from the top-level secrets) to set as environment variables",
+			"StopSignal":           "Signal sent to ask the process to stop (default: SIGTERM)",
+			"StopTimeout":          "Time to wait after sending the stop signal before killing the process (default: 10s)",
+			"SuccessExitCodes":     "Exit codes (in addition to 0) that count as success",
+			"Timeout":              "Max time each run of the command can take before it's stopped",
+			"Watch":                "Restart the process when files change",
+			"WorkDir":              "Working directory for the command (relative to the config file; default: the config file's directory)",
+		},
+		"ReadyConf": {
+			"Exec":     "Shell command that must exit successfully",
+			"HTTP":     "URL that must respond to a GET request",
+			"Interval": "Time between checks (default: 1s)",
+			"Log":      "Regex that must match a line of the process's stdout",
+			"Status":   "Expected HTTP status code (default: any 2xx)",
+			"TCP":      "Address (or port on localhost) that must accept TCP connections",
+			"Timeout":  "Max time to wait for the process to be ready (default: no limit)",
+		},
+		"SecretConf": {
+			"Command":       "Shell command that prints the value (e.g. \"pass show db\")",
+			"EncryptedFile": "Encrypted env file to read the value from (relative to the config file; see \"fun-run secrets\")",
+			"File":          "File to read the value from (relative to the config file)",
+			"Key":           "Name of the value in the encrypted file (default: the secret's name)",
+		},
+		"WatchConf": {
+			"Build":    "Shell command to run before restarting (the restart is skipped if it fails)",
+			"Debounce": "Time to wait for changes to settle before restarting (default: 500ms)",
+			"Exclude":  "Globs of files (or directories) to ignore",
+			"Include":  "Globs of files that trigger a restart (default: any file)",
+			"Paths":    "Files or directories to watch, relative to the workdir (default: the workdir)",
+		},
+		"logProbe": {
+			"buf":     "",
+			"matched": "",
+			"re":      "",
+		},
+		"watcher": {
+			"base": "Directory the paths and globs are relative to",
+			"cmd":  "",
+			"conf": "",
+			"fsw":  "",
+			"man":  "",
+		},
+	},
+	enums: map[string][]string{
+		"DependCondition": {"started", "ready", "completed"},
+		"EventType":       {"starting", "start-failed", "waiting", "not-starting", "ready", "ready-failed", "stopping", "killing", "stopped", "timed-out", "finished", "restarting", "giving-up", "changed", "building", "build-failed", "shutting-down", "log"},
+		"FailurePolicy":   {"continue", "stop-all"},
+		"LogFormat":       {"text", "json"},
+		"RestartPolicy":   {"never", "on-fail", "always"},
+	},
+}
@@ -0,0 +1,57 @@
+package funrun
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaDocsUpToDate(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go isn't on the PATH")
+	}
+	out := filepath.Join(t.TempDir(), "schema_docs.go")
+	cmd := exec.Command(goBin, "run", "gendocs.go", "-o", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("gendocs failed: %v\n%s", err, b)
+	}
+
+	want, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("schema_docs.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error(`schema_docs.go is out of date (run "go generate ./pkg/funrun")`)
+	}
+}
+
+func TestConfSchemaDocs(t *testing.T) {
+	s := ConfSchema()
+	proc := s.Definitions["ProcConf"]
+	if proc == nil {
+		t.Fatal("no definition for ProcConf")
+	}
+	if proc.Description != docs.types["ProcConf"] || proc.Description == "" {
+		t.Errorf("ProcConf description = %q, want %q", proc.Description, docs.types["ProcConf"])
+	}
+
+	restart := proc.Properties["restart"]
+	if restart == nil {
+		t.Fatal("no restart property")
+	}
+	if restart.Description == "" {
+		t.Error("restart has no description")
+	}
+	want := []string{string(RestartNever), string(RestartOnFail), string(RestartAlways)}
+	if !reflect.DeepEqual(restart.Enum, want) {
+		t.Errorf("restart enum = %q, want %q", restart.Enum, want)
+	}
+}
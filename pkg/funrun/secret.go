@@ -0,0 +1,393 @@
+package funrun
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecretsKeyEnvVar is the environment variable that can be set to the
+// (hex-encoded) key used to decrypt encrypted secrets files.
+const SecretsKeyEnvVar = "FUN_RUN_SECRETS_KEY"
+
+// secretsHeader is the first line of an encrypted secrets file.
+const secretsHeader = "fun-run-secrets:v1"
+
+// minRedactLen is the length below which secret values aren't
+// redacted from the output (since they'd match too often).
+const minRedactLen = 4
+
+// SecretConf configures where a secret's value comes from. Exactly
+// one of the sources must be set.
+type SecretConf struct {
+	File          string `json:"file,omitempty" yaml:"file,omitempty"`                     // File to read the value from (relative to the config file)
+	Command       string `json:"command,omitempty" yaml:"command,omitempty"`               // Shell command that prints the value (e.g. "pass show db")
+	EncryptedFile string `json:"encrypted_file,omitempty" yaml:"encrypted_file,omitempty"` // Encrypted env file to read the value from (relative to the config file; see "fun-run secrets")
+	Key           string `json:"key,omitempty" yaml:"key,omitempty"`                       // Name of the value in the encrypted file (default: the secret's name)
+}
+
+// validate checks that the secret has exactly one source.
+func (s *SecretConf) validate() error {
+	n := 0
+	for _, src := range []string{s.File, s.Command, s.EncryptedFile} {
+		if src != "" {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return fmt.Errorf("must set one of file, command or encrypted_file")
+	case n > 1:
+		return fmt.Errorf("can only set one of file, command or encrypted_file")
+	case s.Key != "" && s.EncryptedFile == "":
+		return fmt.Errorf("key can only be set with encrypted_file")
+	}
+	return nil
+}
+
+// validateSecrets checks the secrets, and that each process only
+// uses secrets that exist.
+func (c *Conf) validateSecrets(v *validator) {
+	secrets := getKey(v.src.root, "secrets")
+	names := make([]string, 0, len(c.Secrets))
+	for name := range c.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := getKey(secrets, name)
+		if !isEnvName(name) {
+			v.add(n, "invalid secret name %q (must be a valid environment variable name)", name)
+		}
+		sc := c.Secrets[name]
+		if sc == nil {
+			v.add(n, "secret %q is empty", name)
+			continue
+		}
+		if err := sc.validate(); err != nil {
+			v.add(n, "invalid secret %q: %v", name, err)
+		}
+	}
+
+	for i, p := range c.Procs {
+		if p == nil {
+			continue
+		}
+		for j, name := range p.Secrets {
+			if _, ok := c.Secrets[name]; !ok {
+				n := v.procKey(i, "secrets")
+				if n != nil && n.Kind == yaml.SequenceNode && j < len(n.Content) {
+					n = n.Content[j]
+				}
+				v.add(n, "process %q uses unknown secret %q", p.Name, name)
+			}
+		}
+	}
+}
+
+// isEnvName reports whether a string is a valid
+// environment variable name.
+func isEnvName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveSecretPaths makes the secrets' file paths relative to the
+// config file they were set in (and expands a leading "~/").
+func (s *confSource) resolveSecretPaths(c *Conf) {
+	secrets := getKey(s.root, "secrets")
+	for name, sc := range c.Secrets {
+		if sc == nil {
+			continue
+		}
+		n := getKey(secrets, name)
+		for _, p := range []*string{&sc.File, &sc.EncryptedFile} {
+			if *p == "" {
+				continue
+			}
+			if strings.HasPrefix(*p, "~/") {
+				if home, err := os.UserHomeDir(); err == nil {
+					*p = filepath.Join(home, (*p)[2:])
+				}
+			}
+			if f, ok := s.files[n]; ok && f != stdinName && !filepath.IsAbs(*p) {
+				*p = filepath.Join(filepath.Dir(f), *p)
+			}
+		}
+	}
+}
+
+// resolveSecrets gets the values of the secrets used by the config's
+// processes. Each encrypted file is only decrypted once.
+func (c *Conf) resolveSecrets() (map[string]string, error) {
+	var names []string
+	used := make(map[string]bool)
+	for _, p := range c.Procs {
+		for _, name := range p.Secrets {
+			if !used[name] {
+				used[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	vals := make(map[string]string, len(names))
+	files := make(map[string]map[string]string)
+	for _, name := range names {
+		sc, ok := c.Secrets[name]
+		if !ok || sc == nil {
+			return nil, fmt.Errorf("unknown secret %q", name)
+		}
+		v, err := sc.value(name, files)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %q: %w", name, err)
+		}
+		vals[name] = v
+	}
+	return vals, nil
+}
+
+// value gets the secret's value.
+func (s *SecretConf) value(name string, files map[string]map[string]string) (string, error) {
+	switch {
+	case s.File != "":
+		b, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+
+	case s.Command != "":
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", s.Command)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("command %q failed: %w: %s", s.Command, err, msg)
+			}
+			return "", fmt.Errorf("command %q failed: %w", s.Command, err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+
+	// Decrypt the file (if it hasn't been already)...
+	vals, ok := files[s.EncryptedFile]
+	if !ok {
+		var err error
+		if vals, err = ReadEncryptedEnv(s.EncryptedFile); err != nil {
+			return "", err
+		}
+		files[s.EncryptedFile] = vals
+	}
+
+	// ...and get the value from it
+	key := s.Key
+	if key == "" {
+		key = name
+	}
+	v, ok := vals[key]
+	if !ok {
+		return "", fmt.Errorf("%q isn't set in %s", key, s.EncryptedFile)
+	}
+	return v, nil
+}
+
+// SecretsKeyFile returns the default path of the key used to encrypt
+// and decrypt secrets files.
+func SecretsKeyFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fun-run", "secrets.key"), nil
+}
+
+// GenerateSecretsKey writes a new random key to the default key file,
+// returning its path. An existing key isn't overwritten.
+func GenerateSecretsKey() (string, error) {
+	path, err := SecretsKeyFile()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("key file %s already exists", path)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// secretsKey returns the key from FUN_RUN_SECRETS_KEY or, if that
+// isn't set, from the default key file.
+func secretsKey() ([]byte, error) {
+	s := os.Getenv(SecretsKeyEnvVar)
+	if s == "" {
+		path, err := SecretsKeyFile()
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no secrets key (set %s or run \"fun-run secrets keygen\")", SecretsKeyEnvVar)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets key: %w", err)
+		}
+		s = string(b)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid secrets key (expected 64 hex characters)")
+	}
+	return key, nil
+}
+
+// EncryptSecrets encrypts the contents of a secrets file (an
+// env file) with the secrets key.
+func EncryptSecrets(plain []byte) ([]byte, error) {
+	gcm, err := secretsCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(secretsHeader))
+	return []byte(secretsHeader + "\n" + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptSecrets decrypts a file encrypted with EncryptSecrets.
+func DecryptSecrets(b []byte) ([]byte, error) {
+	header, body, _ := strings.Cut(string(b), "\n")
+	if strings.TrimSpace(header) != secretsHeader {
+		return nil, fmt.Errorf("not an encrypted secrets file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted secrets file: %w", err)
+	}
+	gcm, err := secretsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted secrets file")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(secretsHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets (wrong key?)")
+	}
+	return plain, nil
+}
+
+// ReadEncryptedEnv reads an encrypted env file.
+func ReadEncryptedEnv(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := DecryptSecrets(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ParseDotEnv(bytes.NewReader(plain))
+}
+
+func secretsCipher() (cipher.AEAD, error) {
+	key, err := secretsKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretRef returns the placeholder that a reference to a secret is
+// expanded to when the config is read. Placeholders are replaced with
+// the secret's value when the process is run. (They can't clash with
+// anything else, since commands and env values can't contain NULs.)
+func secretRef(name string) string {
+	return "\x00" + name + "\x00"
+}
+
+// fillSecrets replaces the secret placeholders in a string with
+// the secrets' values.
+func fillSecrets(s string, secrets map[string]string) string {
+	if !strings.Contains(s, "\x00") {
+		return s
+	}
+	for name, v := range secrets {
+		s = strings.ReplaceAll(s, secretRef(name), v)
+	}
+	return s
+}
+
+// showSecrets replaces the secret placeholders in a string
+// with a description of the secret.
+func showSecrets(s string) string {
+	for {
+		i := strings.IndexByte(s, 0)
+		if i < 0 {
+			return s
+		}
+		j := strings.IndexByte(s[i+1:], 0)
+		if j < 0 {
+			return s
+		}
+		s = s[:i] + "<secret " + s[i+1:i+1+j] + ">" + s[i+2+j:]
+	}
+}
+
+// redact replaces the secret values in a string.
+func redact(s string, secrets []string) string {
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, "********")
+	}
+	return s
+}
+
+// redactions returns the secret values that should be redacted from
+// the output, longest first (so a value that contains another is
+// redacted whole).
+func redactions(secrets map[string]string) []string {
+	var vals []string
+	for _, v := range secrets {
+		if len(v) >= minRedactLen {
+			vals = append(vals, v)
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool { return len(vals[i]) > len(vals[j]) })
+	return vals
+}
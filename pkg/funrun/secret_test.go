@@ -0,0 +1,203 @@
+package funrun
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setSecretsKey sets the secrets key to a key made of one byte.
+func setSecretsKey(t *testing.T, b byte) {
+	t.Helper()
+	t.Setenv(SecretsKeyEnvVar, hex.EncodeToString(bytes.Repeat([]byte{b}, 32)))
+}
+
+func TestEncryptSecretsRoundTrip(t *testing.T) {
+	setSecretsKey(t, 1)
+	plain := []byte("DB_PASSWORD=hunter2\nAPI_KEY=abc123\n")
+	enc, err := EncryptSecrets(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(enc), secretsHeader+"\n") {
+		t.Errorf("encrypted file starts with %q, want the %q header", strings.SplitN(string(enc), "\n", 2)[0], secretsHeader)
+	}
+	if bytes.Contains(enc, []byte("hunter2")) {
+		t.Error("encrypted file contains the plain text")
+	}
+
+	got, err := DecryptSecrets(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("DecryptSecrets() = %q, want %q", got, plain)
+	}
+
+	// Each encryption uses a new nonce
+	again, err := EncryptSecrets(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(enc, again) {
+		t.Error("encrypting twice gave the same output")
+	}
+}
+
+func TestDecryptSecretsErrors(t *testing.T) {
+	setSecretsKey(t, 1)
+	enc, err := EncryptSecrets([]byte("A=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, enc...)
+	i := len(secretsHeader) + 1
+	if tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+
+	tests := []struct {
+		name string
+		key  byte // Key to decrypt with
+		b    string
+		err  string
+	}{
+		{name: "wrong key", key: 2, b: string(enc), err: "failed to decrypt secrets (wrong key?)"},
+		{name: "tampered", key: 1, b: string(tampered), err: "failed to decrypt secrets"},
+		{name: "not encrypted", key: 1, b: "A=1\n", err: "not an encrypted secrets file"},
+		{name: "bad base64", key: 1, b: secretsHeader + "\n!!!\n", err: "invalid encrypted secrets file"},
+		{name: "too short", key: 1, b: secretsHeader + "\nAAAA\n", err: "invalid encrypted secrets file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSecretsKey(t, tt.key)
+			_, err := DecryptSecrets([]byte(tt.b))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("DecryptSecrets() error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSecretsKeyInvalid(t *testing.T) {
+	for _, key := range []string{"not hex", "abcd"} {
+		t.Setenv(SecretsKeyEnvVar, key)
+		if _, err := EncryptSecrets([]byte("A=1")); err == nil || !strings.Contains(err.Error(), "invalid secrets key") {
+			t.Errorf("key %q: error = %v, want an invalid key error", key, err)
+		}
+	}
+}
+
+func TestResolveEncryptedSecrets(t *testing.T) {
+	setSecretsKey(t, 1)
+	dir := t.TempDir()
+	enc, err := EncryptSecrets([]byte("DB_PASSWORD=hunter2\nOTHER=value\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "secrets.enc", string(enc))
+	writeFile(t, dir, "token", "t0ken\n")
+	conf, err := ReadConf(writeFile(t, dir, "fun-run.yaml", `
+secrets:
+  DB_PASSWORD: {encrypted_file: secrets.enc}
+  RENAMED: {encrypted_file: secrets.enc, key: OTHER}
+  TOKEN: {file: token}
+  CMD: {command: "printf 'from cmd\n'"}
+procs:
+  - name: a
+    cmd: echo
+    secrets: [DB_PASSWORD, RENAMED, TOKEN, CMD]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := conf.resolveSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"DB_PASSWORD": "hunter2", "RENAMED": "value", "TOKEN": "t0ken", "CMD": "from cmd"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveSecrets() = %v, want %v", got, want)
+	}
+
+	// A missing key is an error
+	conf.Secrets["RENAMED"].Key = "MISSING"
+	if _, err := conf.resolveSecrets(); err == nil || !strings.Contains(err.Error(), `"MISSING" isn't set in `+filepath.Join(dir, "secrets.enc")) {
+		t.Errorf("resolveSecrets() error = %v, want a missing key error", err)
+	}
+}
+
+func TestRedactions(t *testing.T) {
+	// Short values are left alone, and longer ones come first
+	vals := redactions(map[string]string{"A": "abc", "B": "secret", "C": "secret-long"})
+	if want := []string{"secret-long", "secret"}; !reflect.DeepEqual(vals, want) {
+		t.Fatalf("redactions() = %q, want %q", vals, want)
+	}
+	if got := redact("secret-long and secret and abc", vals); got != "******** and ******** and abc" {
+		t.Errorf("redact() = %q", got)
+	}
+}
+
+func TestSecretPlaceholders(t *testing.T) {
+	s := "psql -p " + secretRef("PASS") + " -u " + secretRef("USER")
+	if got := fillSecrets(s, map[string]string{"PASS": "hunter2", "USER": "admin"}); got != "psql -p hunter2 -u admin" {
+		t.Errorf("fillSecrets() = %q", got)
+	}
+	if got := showSecrets(s); got != "psql -p <secret PASS> -u <secret USER>" {
+		t.Errorf("showSecrets() = %q", got)
+	}
+}
+
+func TestEnvironSecrets(t *testing.T) {
+	conf := mustReadConf(t, `
+secrets:
+  TOK: {command: "echo t0ken"}
+procs:
+  - name: a
+    cmd: echo
+    clear_envs: true
+    envs: {TOK: overridden}
+    secrets: [TOK]
+  - name: b
+    cmd: echo
+    clear_envs: true
+    envs: {URL: "https://${TOK}@example.com"}
+    secrets: [TOK]
+`)
+	tests := []struct {
+		name    string
+		environ []string // From Environ
+		run     []string // What the process gets
+	}{
+		{
+			name:    "a",
+			environ: []string{"TOK=<secret TOK>"},
+			run:     []string{"TOK=t0ken"},
+		},
+		{
+			name:    "b",
+			environ: []string{"TOK=<secret TOK>", "URL=https://<secret TOK>@example.com"},
+			run:     []string{"TOK=t0ken", "URL=https://t0ken@example.com"},
+		},
+	}
+	for i, tt := range tests {
+		p := conf.Procs[i]
+
+		// The secrets are listed, without their values...
+		if got := p.Environ(); !reflect.DeepEqual(got, tt.environ) {
+			t.Errorf("%s: Environ() = %q, want %q", tt.name, got, tt.environ)
+		}
+
+		// ...and the process gets their values
+		c := NewCommand(p)
+		c.secrets = map[string]string{"TOK": "t0ken"}
+		if got := c.fmtEnvSlice(); !reflect.DeepEqual(got, tt.run) {
+			t.Errorf("%s: fmtEnvSlice() = %q, want %q", tt.name, got, tt.run)
+		}
+	}
+}
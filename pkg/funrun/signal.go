@@ -0,0 +1,40 @@
+package funrun
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// signals maps signal names (without the "SIG" prefix) to
+// the signals that can be used to stop a process.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseSignal returns the signal with the given name. Names are
+// case-insensitive and the "SIG" prefix is optional (e.g. "SIGTERM",
+// "sigterm" and "TERM" are all valid).
+func parseSignal(name string) (os.Signal, error) {
+	n := strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	s, ok := signals[n]
+	if !ok {
+		return nil, fmt.Errorf("unknown signal %q", name)
+	}
+	return s, nil
+}
+
+// signalName returns the name of a signal, with the "SIG" prefix.
+func signalName(sig os.Signal) string {
+	for n, s := range signals {
+		if s == sig {
+			return "SIG" + n
+		}
+	}
+	return sig.String()
+}
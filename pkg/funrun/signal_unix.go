@@ -0,0 +1,11 @@
+//go:build !windows
+
+package funrun
+
+import "syscall"
+
+func init() {
+	// Add the signals that only exist on unix systems
+	signals["USR1"] = syscall.SIGUSR1
+	signals["USR2"] = syscall.SIGUSR2
+}
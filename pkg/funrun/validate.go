@@ -0,0 +1,318 @@
+package funrun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single problem found in a config.
+type Problem struct {
+	File    string // File the problem is in (if known)
+	Line    int    // Line the problem is on (if known)
+	Column  int    // Column the problem is at (if known)
+	Message string // What's wrong
+}
+
+func (p Problem) String() string {
+	var pos []string
+	if p.File != "" {
+		pos = append(pos, p.File)
+	}
+	if p.Line > 0 {
+		pos = append(pos, strconv.Itoa(p.Line))
+		if p.Column > 0 {
+			pos = append(pos, strconv.Itoa(p.Column))
+		}
+	}
+	if len(pos) == 0 {
+		return p.Message
+	}
+	return strings.Join(pos, ":") + ": " + p.Message
+}
+
+// ConfError is returned when a config has problems. It
+// lists every problem found.
+type ConfError struct {
+	Problems []Problem
+}
+
+func (e *ConfError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].String()
+	}
+	lines := []string{fmt.Sprintf("found %d problems:", len(e.Problems))}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator collects the problems found in a config.
+type validator struct {
+	src        *confSource
+	problems   []Problem
+	unexpanded map[*ProcConf]bool // Processes with values that couldn't be expanded
+}
+
+// add records a problem with a node (which can be nil if the
+// problem isn't from a specific part of the config).
+func (v *validator) add(n *yaml.Node, format string, a ...interface{}) {
+	p := Problem{Message: fmt.Sprintf(format, a...)}
+	if n != nil {
+		p.File = v.src.files[n]
+		p.Line, p.Column = n.Line, n.Column
+	}
+	v.problems = append(v.problems, p)
+}
+
+// err returns the problems found as an error (or nil).
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	// Sort the problems by where they are in the files (keeping
+	// the files in the order they were read)
+	order := make(map[string]int)
+	for _, p := range v.problems {
+		if _, ok := order[p.File]; !ok {
+			order[p.File] = len(order)
+		}
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return &ConfError{Problems: v.problems}
+}
+
+// procKey returns the node for a key of the i-th process, falling
+// back to the node for the process itself.
+func (v *validator) procKey(i int, key string) *yaml.Node {
+	n := v.src.procNode(i)
+	if k := getKey(n, key); k != nil {
+		return k
+	}
+	return n
+}
+
+// depNode returns the node for the j-th dependency of
+// the i-th process.
+func (v *validator) depNode(i, j int) *yaml.Node {
+	deps := v.procKey(i, "depends_on")
+	if deps != nil && deps.Kind == yaml.SequenceNode && j < len(deps.Content) {
+		return deps.Content[j]
+	}
+	return deps
+}
+
+// lineErr matches the position at the start of yaml's error messages.
+var lineErr = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// decodeProblems converts the error from parsing a file
+// into problems.
+func decodeProblems(file string, root *yaml.Node, err error) []Problem {
+	msgs := []string{err.Error()}
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs = te.Errors
+	}
+
+	var ps []Problem
+	for _, msg := range msgs {
+		p := Problem{File: file, Message: strings.TrimPrefix(msg, "yaml: ")}
+		if m := lineErr.FindStringSubmatch(msg); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Column = columnAt(root, p.Line)
+			p.Message = m[2]
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// columnAt returns the column of the first node on a line (or 0).
+func columnAt(n *yaml.Node, line int) int {
+	if n = nodeAt(n, line); n != nil {
+		return n.Column
+	}
+	return 0
+}
+
+// nodeAt returns the first node on a line (or nil).
+func nodeAt(n *yaml.Node, line int) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	if n.Line == line && n.Kind != yaml.DocumentNode {
+		return n
+	}
+	for _, c := range n.Content {
+		if f := nodeAt(c, line); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// checkKeys reports keys in a node that don't match a field
+// of the type it's decoded into.
+func (v *validator) checkKeys(n *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		// Some types (e.g. dependencies) can also be written as scalars
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			ft, ok := fields[k.Value]
+			if !ok {
+				v.add(k, "unknown key %q%s", k.Value, suggest(k.Value, fields))
+				continue
+			}
+			v.checkKeys(n.Content[i+1], ft)
+		}
+
+	case reflect.Slice:
+		if n.Kind == yaml.SequenceNode {
+			for _, c := range n.Content {
+				v.checkKeys(c, t.Elem())
+			}
+		}
+
+	case reflect.Map:
+		if n.Kind == yaml.MappingNode {
+			for i := 1; i < len(n.Content); i += 2 {
+				v.checkKeys(n.Content[i], t.Elem())
+			}
+		}
+	}
+}
+
+// yamlFields returns the yaml keys of a struct's
+// fields, along with their types.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// suggest returns a "did you mean" hint with the closest
+// key to a misspelled one (if any are close).
+func suggest(key string, fields map[string]reflect.Type) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDist := "", 3
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkWorkDir reports a working directory that doesn't exist.
+func (v *validator) checkWorkDir(i int, p *ProcConf) {
+	if p.WorkDir == "" || v.unexpanded[p] || strings.Contains(p.WorkDir, "\x00") {
+		return
+	}
+	info, err := os.Stat(p.WorkDir)
+	switch {
+	case err != nil:
+		v.add(v.procKey(i, "workdir"), "workdir %q for process %q doesn't exist", p.WorkDir, p.Name)
+	case !info.IsDir():
+		v.add(v.procKey(i, "workdir"), "workdir %q for process %q isn't a directory", p.WorkDir, p.Name)
+	}
+}
+
+// checkExecutable reports a command that can't be found. Commands
+// for processes that set their own PATH are skipped, since they're
+// looked up in that PATH when they're run.
+func (v *validator) checkExecutable(i int, p *ProcConf) {
+	if p.Cmd == "" || v.unexpanded[p] || strings.Contains(p.Cmd, "\x00") {
+		return
+	}
+	if _, ok := p.Envs["PATH"]; ok {
+		return
+	}
+
+	// A relative path is relative to the working directory
+	path := p.Cmd
+	if strings.ContainsRune(path, filepath.Separator) && !filepath.IsAbs(path) && p.WorkDir != "" {
+		path = filepath.Join(p.WorkDir, path)
+		if !strings.ContainsRune(path, filepath.Separator) {
+			path = "." + string(filepath.Separator) + path
+		}
+	}
+	if _, err := exec.LookPath(path); err != nil {
+		v.add(v.procKey(i, "cmd"), "can't find command %q for process %q", p.Cmd, p.Name)
+	}
+}
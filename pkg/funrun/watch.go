@@ -0,0 +1,263 @@
+package funrun
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounce is how long to wait after a file change for
+// things to settle before restarting, if a debounce isn't set.
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// WatchConf configures restarting a process when files change.
+type WatchConf struct {
+	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`       // Files or directories to watch, relative to the workdir (default: the workdir)
+	Include  []string `json:"include,omitempty" yaml:"include,omitempty"`   // Globs of files that trigger a restart (default: any file)
+	Exclude  []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`   // Globs of files (or directories) to ignore
+	Debounce Duration `json:"debounce,omitempty" yaml:"debounce,omitempty"` // Time to wait for changes to settle before restarting (default: 500ms)
+	Build    string   `json:"build,omitempty" yaml:"build,omitempty"`       // Shell command to run before restarting (the restart is skipped if it fails)
+}
+
+// validate checks that the watch config is usable.
+func (w *WatchConf) validate() error {
+	for _, g := range append(append([]string{}, w.Include...), w.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether a slash-separated path matches a glob. A
+// "**" segment matches any number of directories. A glob without a
+// slash is matched against the file's base name.
+func matchGlob(glob, name string) bool {
+	if !strings.Contains(glob, "/") {
+		ok, _ := path.Match(glob, path.Base(name))
+		return ok
+	}
+	return matchSegments(
+		strings.Split(strings.Trim(glob, "/"), "/"),
+		strings.Split(name, "/"),
+	)
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// watcher restarts a command when the files it watches change.
+type watcher struct {
+	man  *Manager
+	cmd  *Command
+	conf *WatchConf
+	base string // Directory the paths and globs are relative to
+	fsw  *fsnotify.Watcher
+}
+
+// newWatcher starts watching a command's files.
+func newWatcher(m *Manager, cmd *Command) (*watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		man:  m,
+		cmd:  cmd,
+		conf: cmd.conf.Watch,
+		base: cmd.conf.WorkDir,
+		fsw:  fsw,
+	}
+	if w.base == "" {
+		w.base = "."
+	}
+
+	paths := w.conf.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(w.base, p)
+		}
+		if err := w.add(p); err != nil {
+			fsw.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// rel returns a file's path relative to the base directory,
+// using forward slashes.
+func (w *watcher) rel(p string) string {
+	if r, err := filepath.Rel(w.base, p); err == nil {
+		p = r
+	}
+	return filepath.ToSlash(p)
+}
+
+// excluded reports whether a file matches one of the exclude globs.
+func (w *watcher) excluded(p string) bool {
+	r := w.rel(p)
+	for _, g := range w.conf.Exclude {
+		if matchGlob(g, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// included reports whether a change to a file should
+// trigger a restart.
+func (w *watcher) included(p string) bool {
+	if w.excluded(p) {
+		return false
+	}
+	if len(w.conf.Include) == 0 {
+		return true
+	}
+	r := w.rel(p)
+	for _, g := range w.conf.Include {
+		if matchGlob(g, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// add watches a file or, for a directory, the directory and
+// everything below it (other than excluded and hidden directories).
+func (w *watcher) add(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("failed to watch %q: %w", root, err)
+	}
+	if !info.IsDir() {
+		return w.fsw.Add(root)
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if p != root && (strings.HasPrefix(d.Name(), ".") || w.excluded(p)) {
+			return filepath.SkipDir
+		}
+		if err := w.fsw.Add(p); err != nil {
+			return fmt.Errorf("failed to watch %q: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// run handles file changes until the context is cancelled.
+func (w *watcher) run(ctx context.Context) {
+	defer w.fsw.Close()
+
+	debounce := time.Duration(w.conf.Debounce)
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	var changed string
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+
+			// Watch new directories...
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if !w.excluded(ev.Name) {
+						w.add(ev.Name)
+					}
+					continue
+				}
+			}
+
+			// ...and wait for the changes to settle
+			if ev.Op == fsnotify.Chmod || !w.included(ev.Name) {
+				continue
+			}
+			changed = w.rel(ev.Name)
+			timer.Reset(debounce)
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.cmd.werr.Event(EventLog, "Watch error: %s\n", err)
+
+		case <-timer.C:
+			w.restart(ctx, changed)
+		}
+	}
+}
+
+// restart runs the build command (if there is one) and then
+// restarts the command.
+func (w *watcher) restart(ctx context.Context, changed string) {
+	// Don't start a command that's been stopped on purpose
+	if w.cmd.isStopped() && !w.cmd.isActive() {
+		return
+	}
+	w.cmd.wout.Event(EventChanged, "%s changed\n", changed)
+
+	if w.conf.Build != "" {
+		w.cmd.wout.Event(EventBuilding, "Building...\n")
+		if err := w.build(ctx); err != nil {
+			if ctx.Err() == nil {
+				w.cmd.werr.Event(EventBuildFailed, "Build failed, not restarting: %s\n", err)
+			}
+			return
+		}
+	}
+
+	if err := w.man.Restart(w.cmd.Name()); err != nil && ctx.Err() == nil {
+		w.cmd.werr.Event(EventLog, "Failed to restart: %s\n", err)
+	}
+}
+
+// build runs the build command in the command's environment.
+func (w *watcher) build(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", w.conf.Build)
+	cmd.Dir = w.cmd.conf.WorkDir
+	cmd.Env = w.cmd.fmtEnvSlice()
+	cmd.Stdout = w.cmd.wout
+	cmd.Stderr = w.cmd.werr
+	return cmd.Run()
+}
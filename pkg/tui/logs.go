@@ -0,0 +1,45 @@
+package tui
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxLines is the max number of lines kept for each log.
+const maxLines = 2000
+
+// logBuffer holds the most recent lines of a log.
+type logBuffer struct {
+	lines []string
+}
+
+func (b *logBuffer) add(line string) {
+	b.lines = append(b.lines, line)
+	if len(b.lines) > maxLines {
+		b.lines = b.lines[len(b.lines)-maxLines:]
+	}
+}
+
+// lineWriter is an io.Writer that splits the data written to it
+// into lines and passes each complete line to a callback.
+type lineWriter struct {
+	buf  []byte
+	send func(line string)
+	sync.Mutex
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
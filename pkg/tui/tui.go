@@ -0,0 +1,290 @@
+// Package tui provides an interactive terminal dashboard
+// for a fun-run process manager.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/a-poor/fun-run/pkg/funrun"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/truncate"
+)
+
+const (
+	allName      = "(all)" // Sidebar name for the merged log view
+	sidebarWidth = 40      // Width of the sidebar (including its border)
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true)
+	selectedStyle = lipgloss.NewStyle().Bold(true).Reverse(true)
+	helpStyle     = lipgloss.NewStyle().Faint(true)
+	sidebarStyle  = lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, true, false, false).
+			PaddingRight(1)
+
+	statusColors = map[funrun.CmdStatus]lipgloss.Color{
+		funrun.CmdNotStarted: lipgloss.Color("8"),
+		funrun.CmdRunning:    lipgloss.Color("11"),
+		funrun.CmdReady:      lipgloss.Color("10"),
+		funrun.CmdDone:       lipgloss.Color("12"),
+		funrun.CmdFailed:     lipgloss.Color("9"),
+		funrun.CmdStopped:    lipgloss.Color("8"),
+	}
+)
+
+type (
+	logMsg struct {
+		name string // Name of the process ("" for the manager)
+		line string
+	}
+	tickMsg   time.Time
+	doneMsg   struct{ err error }
+	resultMsg struct{ text string }
+)
+
+type model struct {
+	man      *funrun.Manager
+	names    []string // Sidebar entries (the merged view, then each process)
+	selected int
+	logs     map[string]*logBuffer
+	merged   logBuffer
+	view     viewport.Model
+	width    int
+	height   int
+	message  string // The latest status message
+	quitting bool
+}
+
+// Run runs the manager with an interactive dashboard in place of the
+// usual prefixed output. It returns once the manager has stopped.
+func Run(ctx context.Context, man *funrun.Manager, conf *funrun.Conf) error {
+	m := &model{
+		man:   man,
+		names: []string{allName},
+		logs:  make(map[string]*logBuffer),
+		view:  viewport.New(0, 0),
+	}
+	for _, p := range conf.Procs {
+		m.names = append(m.names, p.Name)
+		m.logs[p.Name] = &logBuffer{}
+	}
+
+	prog := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+
+	// Send the process output to the dashboard rather than the terminal...
+	man.SetProcOutputs(func(name, stream string) io.Writer {
+		return &lineWriter{send: func(line string) {
+			prog.Send(logMsg{name: name, line: line})
+		}}
+	})
+	mw := &lineWriter{send: func(line string) {
+		prog.Send(logMsg{line: line})
+	}}
+	man.SetOutputs(mw, mw)
+
+	// Keep the manager running so processes can be started again
+	man.SetKeepAlive(true)
+
+	// Run the manager in the background...
+	go func() {
+		err := man.Run(ctx)
+		prog.Send(doneMsg{err: err})
+	}()
+
+	// ...and the dashboard in the foreground
+	_, err := prog.Run()
+	if err != nil {
+		man.Shutdown()
+	}
+	return err
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+func (m *model) Init() tea.Cmd {
+	return tick()
+}
+
+// selectedName returns the name of the selected process (or "" if the
+// merged view is selected).
+func (m *model) selectedName() string {
+	if m.selected == 0 {
+		return ""
+	}
+	return m.names[m.selected]
+}
+
+// control runs a manager operation on the selected process in the
+// background, reporting the result as a status message.
+func (m *model) control(verb string, f func(string) error) tea.Cmd {
+	name := m.selectedName()
+	if name == "" {
+		m.message = "Select a process first"
+		return nil
+	}
+	m.message = fmt.Sprintf("%s %s...", verb, name)
+	return func() tea.Msg {
+		if err := f(name); err != nil {
+			return resultMsg{text: fmt.Sprintf("Error: %s", err)}
+		}
+		return resultMsg{text: fmt.Sprintf("%s %s: done", verb, name)}
+	}
+}
+
+// refresh updates the log pane's content for the current selection.
+func (m *model) refresh() {
+	atBottom := m.view.AtBottom()
+
+	lines := m.merged.lines
+	if name := m.selectedName(); name != "" {
+		lines = m.logs[name].lines
+	}
+
+	w := uint(m.view.Width)
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = truncate.String(l, w)
+	}
+	m.view.SetContent(strings.Join(out, "\n"))
+
+	if atBottom {
+		m.view.GotoBottom()
+	}
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.view.Width = msg.Width - sidebarWidth - 1
+		m.view.Height = msg.Height - 2
+		m.refresh()
+		m.view.GotoBottom()
+
+	case tickMsg:
+		return m, tick()
+
+	case logMsg:
+		if msg.name != "" {
+			m.logs[msg.name].add(msg.line)
+		}
+		m.merged.add(msg.line)
+		if msg.name == "" || m.selected == 0 || msg.name == m.selectedName() {
+			m.refresh()
+		}
+
+	case resultMsg:
+		m.message = msg.text
+
+	case doneMsg:
+		return m, tea.Quit
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.view, cmd = m.view.Update(msg)
+		return m, cmd
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			if !m.quitting {
+				m.quitting = true
+				m.message = "Shutting down..."
+				m.man.Shutdown()
+			}
+		case "up", "k":
+			if m.selected > 0 {
+				m.selected--
+				m.refresh()
+				m.view.GotoBottom()
+			}
+		case "down", "j":
+			if m.selected < len(m.names)-1 {
+				m.selected++
+				m.refresh()
+				m.view.GotoBottom()
+			}
+		case "pgup", "ctrl+u":
+			m.view.HalfViewUp()
+		case "pgdown", "ctrl+d":
+			m.view.HalfViewDown()
+		case "home", "g":
+			m.view.GotoTop()
+		case "end", "G":
+			m.view.GotoBottom()
+		case "r":
+			return m, m.control("Restarting", m.man.Restart)
+		case "s":
+			return m, m.control("Starting", m.man.Start)
+		case "x":
+			return m, m.control("Stopping", m.man.Stop)
+		}
+	}
+	return m, nil
+}
+
+// procLine formats a process's sidebar entry.
+func (m *model) procLine(name string, cmd *funrun.Command) string {
+	status := cmd.Status()
+	dot := lipgloss.NewStyle().Foreground(statusColors[status]).Render("●")
+
+	var uptime string
+	if t := cmd.StartedAt(); !t.IsZero() {
+		uptime = time.Since(t).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s %-14s %-8s ↻%-3d %s",
+		dot,
+		truncate.String(name, 14),
+		status,
+		cmd.Restarts(),
+		uptime,
+	)
+}
+
+func (m *model) sidebar() string {
+	cmds := make(map[string]*funrun.Command)
+	for _, c := range m.man.Commands() {
+		cmds[c.Name()] = c
+	}
+
+	lines := []string{titleStyle.Render("fun-run"), ""}
+	for i, name := range m.names {
+		l := "  " + name
+		if cmd, ok := cmds[name]; ok {
+			l = m.procLine(name, cmd)
+		}
+		l = lipgloss.NewStyle().Width(sidebarWidth - 2).Render(truncate.String(l, sidebarWidth-2))
+		if i == m.selected {
+			l = selectedStyle.Render(l)
+		}
+		lines = append(lines, l)
+	}
+
+	return sidebarStyle.
+		Height(m.height - 2).
+		Width(sidebarWidth - 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+func (m *model) View() string {
+	if m.width == 0 {
+		return ""
+	}
+
+	body := lipgloss.JoinHorizontal(lipgloss.Top, m.sidebar(), " ", m.view.View())
+	help := helpStyle.Render("↑/↓ select • s start • x stop • r restart • pgup/pgdn scroll • q quit")
+	status := truncate.String(m.message, uint(m.width))
+	return lipgloss.JoinVertical(lipgloss.Left, body, status, help)
+}